@@ -0,0 +1,427 @@
+package main
+
+// book archive index, the sorted page list of each book is worked out once and kept
+// along with a few open zip readers, shared by reader, thumbnailer and page counter
+
+import (
+	"archive/zip"
+	"bytes"
+	"container/list"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// archive cache tuning
+var (
+	ArchiveOpenMax  = 8        // zip readers kept open
+	ArchiveTableMax = 256      // page tables kept
+	MaxPageSize     = 16 << 20 // bytes, bigger page is refused rather than read into memory, original can still be streamed
+)
+
+// archives is the shared archive cache
+var archives = NewArchiveCache()
+
+// PageEntry is an image in the book archive
+type PageEntry struct {
+	Name           string // file name in archive
+	Method         uint16 // zip.Store or zip.Deflate
+	Offset         int64  // where the entry data starts in the archive
+	CompressedSize int64
+	Size           int64 // uncompressed
+	index          int   // position in zip central directory
+}
+
+// PageTable is images of the book in reading order, page 1 is Pages[0]
+type PageTable struct {
+	Path  string
+	Mtime time.Time
+	Size  int64
+	Pages []PageEntry
+}
+
+// archiveKey identifies version of archive file, changed file gets different key
+type archiveKey struct {
+	path  string
+	mtime time.Time
+	size  int64
+}
+
+// openArchive is a zip reader in use, closed when evicted and no one is using it
+type openArchive struct {
+	key     archiveKey
+	f       *os.File // stored entries are read straight from it
+	zr      *zip.Reader
+	refs    int
+	evicted bool
+}
+
+// ArchiveCache keeps page tables and open zip readers, least recently used are dropped
+type ArchiveCache struct {
+	mutex    sync.Mutex
+	tables   map[string]*list.Element // by path, value *PageTable
+	tableLRU *list.List
+	opened   map[string]*list.Element // by path, value *openArchive
+	openLRU  *list.List
+}
+
+// NewArchiveCache creates archive cache
+func NewArchiveCache() *ArchiveCache {
+	return &ArchiveCache{
+		tables:   make(map[string]*list.Element),
+		tableLRU: list.New(),
+		opened:   make(map[string]*list.Element),
+		openLRU:  list.New(),
+	}
+}
+
+// statArchive gives current key of archive file
+func statArchive(fpath string) (archiveKey, error) {
+	fstat, err := os.Stat(fpath)
+	if err != nil {
+		return archiveKey{}, err
+	}
+	return archiveKey{path: fpath, mtime: fstat.ModTime(), size: fstat.Size()}, nil
+}
+
+// acquire gives open zip reader of the archive, release must be called when done
+func (ac *ArchiveCache) acquire(key archiveKey) (*openArchive, error) {
+	ac.mutex.Lock()
+	if el, ok := ac.opened[key.path]; ok {
+		oa := el.Value.(*openArchive)
+		if oa.key == key {
+			oa.refs++
+			ac.openLRU.MoveToFront(el)
+			ac.mutex.Unlock()
+			return oa, nil
+		}
+		// file changed
+		ac.dropOpen(el)
+	}
+	ac.mutex.Unlock()
+
+	f, err := os.Open(key.path)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(f, key.size)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	oa := &openArchive{key: key, f: f, zr: zr, refs: 1}
+
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	if el, ok := ac.opened[key.path]; ok {
+		// opened by another request meanwhile, keep the newer one
+		ac.dropOpen(el)
+	}
+	ac.opened[key.path] = ac.openLRU.PushFront(oa)
+	for ac.openLRU.Len() > ArchiveOpenMax {
+		ac.dropOpen(ac.openLRU.Back())
+	}
+
+	return oa, nil
+}
+
+// dropOpen removes zip reader from cache, closed now or when last user releases it. caller holds the lock
+func (ac *ArchiveCache) dropOpen(el *list.Element) {
+	oa := ac.openLRU.Remove(el).(*openArchive)
+	delete(ac.opened, oa.key.path)
+	oa.evicted = true
+	if oa.refs == 0 {
+		oa.f.Close()
+	}
+}
+
+// release gives back zip reader from acquire
+func (ac *ArchiveCache) release(oa *openArchive) {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	oa.refs--
+	if oa.refs == 0 && oa.evicted {
+		oa.f.Close()
+	}
+}
+
+// table gives page table of the archive version, building it from the zip reader if needed
+func (ac *ArchiveCache) table(key archiveKey, oa *openArchive) *PageTable {
+	if pt := ac.cachedTable(key); pt != nil {
+		return pt
+	}
+
+	// list images then natural sort, once per archive version
+	byName := map[string]PageEntry{}
+	names := []string{}
+	for i, f := range oa.zr.File {
+		if !RegexSupportedImageExt.MatchString(f.Name) {
+			continue
+		}
+		offset, err := f.DataOffset()
+		if err != nil {
+			offset = -1
+		}
+		byName[f.Name] = PageEntry{
+			Name:           f.Name,
+			Method:         f.Method,
+			Offset:         offset,
+			CompressedSize: int64(f.CompressedSize64),
+			Size:           int64(f.UncompressedSize64),
+			index:          i,
+		}
+		names = append(names, f.Name)
+	}
+	names = sortNatural(names, RegexSupportedImageExt)
+
+	pt := &PageTable{Path: key.path, Mtime: key.mtime, Size: key.size, Pages: make([]PageEntry, len(names))}
+	for i, name := range names {
+		pt.Pages[i] = byName[name]
+	}
+
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	if el, ok := ac.tables[key.path]; ok {
+		ac.tableLRU.Remove(el)
+	}
+	ac.tables[key.path] = ac.tableLRU.PushFront(pt)
+	for ac.tableLRU.Len() > ArchiveTableMax {
+		old := ac.tableLRU.Remove(ac.tableLRU.Back()).(*PageTable)
+		delete(ac.tables, old.Path)
+	}
+
+	return pt
+}
+
+// cachedTable gives page table when it is cached and still current
+func (ac *ArchiveCache) cachedTable(key archiveKey) *PageTable {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	if el, ok := ac.tables[key.path]; ok {
+		pt := el.Value.(*PageTable)
+		if pt.Mtime.Equal(key.mtime) && pt.Size == key.size {
+			ac.tableLRU.MoveToFront(el)
+			return pt
+		}
+	}
+	return nil
+}
+
+// Table gives page table of the book archive
+func (ac *ArchiveCache) Table(fpath string) (*PageTable, error) {
+	key, err := statArchive(fpath)
+	if err != nil {
+		return nil, err
+	}
+	if pt := ac.cachedTable(key); pt != nil {
+		return pt, nil
+	}
+
+	oa, err := ac.acquire(key)
+	if err != nil {
+		return nil, err
+	}
+	defer ac.release(oa)
+
+	return ac.table(key, oa), nil
+}
+
+// Forget drops page table and closes zip reader of the archive, so file can be removed
+func (ac *ArchiveCache) Forget(fpath string) {
+	ac.mutex.Lock()
+	defer ac.mutex.Unlock()
+	if el, ok := ac.opened[fpath]; ok {
+		ac.dropOpen(el)
+	}
+	if el, ok := ac.tables[fpath]; ok {
+		ac.tableLRU.Remove(el)
+		delete(ac.tables, fpath)
+	}
+}
+
+// entry gives zip file of the page, with the zip reader to release when done
+func (ac *ArchiveCache) entry(fpath string, page int) (*zip.File, PageEntry, *openArchive, error) {
+	key, err := statArchive(fpath)
+	if err != nil {
+		return nil, PageEntry{}, nil, err
+	}
+
+	oa, err := ac.acquire(key)
+	if err != nil {
+		return nil, PageEntry{}, nil, err
+	}
+
+	pt := ac.table(key, oa)
+	if page < 1 || page > len(pt.Pages) {
+		ac.release(oa)
+		return nil, PageEntry{}, nil, errors.New("page beyond file #")
+	}
+
+	// file replaced between stat and open
+	entry := pt.Pages[page-1]
+	if entry.index >= len(oa.zr.File) || oa.zr.File[entry.index].Name != entry.Name {
+		ac.release(oa)
+		return nil, PageEntry{}, nil, errors.New("book changed while reading, try again")
+	}
+
+	return oa.zr.File[entry.index], entry, oa, nil
+}
+
+// archivePage is reader of a page, gives back the zip reader when closed
+type archivePage struct {
+	io.ReadCloser
+	ac *ArchiveCache
+	oa *openArchive
+}
+
+// Close closes page and releases the zip reader
+func (ap *archivePage) Close() error {
+	err := ap.ReadCloser.Close()
+	ap.ac.release(ap.oa)
+	return err
+}
+
+// OpenPage opens page of the book, page starts at 1
+func (ac *ArchiveCache) OpenPage(fpath string, page int) (io.ReadCloser, error) {
+	f, _, oa, err := ac.entry(fpath, page)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		ac.release(oa)
+		return nil, err
+	}
+
+	return &archivePage{ReadCloser: rc, ac: ac, oa: oa}, nil
+}
+
+// ReadPage reads whole page of the book, page starts at 1
+func (ac *ArchiveCache) ReadPage(fpath string, page int) ([]byte, error) {
+	f, entry, oa, err := ac.entry(fpath, page)
+	if err != nil {
+		return nil, err
+	}
+	defer ac.release(oa)
+
+	if entry.Size > int64(MaxPageSize) {
+		return nil, errors.New("page too big " + entry.Name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	// size in zip header is not trusted beyond the limit
+	buf := bytes.NewBuffer(make([]byte, 0, entry.Size+bytes.MinRead))
+	_, err = buf.ReadFrom(io.LimitReader(rc, int64(MaxPageSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if buf.Len() > MaxPageSize {
+		return nil, errors.New("page too big " + entry.Name)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// PageContent is seekable page data for streaming. stored entry is a section of the archive file,
+// deflated entry is decompressed as read, seeking back starts decompressing over
+type PageContent struct {
+	Entry PageEntry
+
+	ac      *ArchiveCache
+	oa      *openArchive
+	section *io.SectionReader // stored entry
+
+	file  *zip.File     // deflated entry
+	rc    io.ReadCloser // decompressing reader, at rcPos
+	rcPos int64
+	pos   int64
+}
+
+// OpenContent opens page of the book for streaming, must be closed
+func (ac *ArchiveCache) OpenContent(fpath string, page int) (*PageContent, error) {
+	f, entry, oa, err := ac.entry(fpath, page)
+	if err != nil {
+		return nil, err
+	}
+
+	pc := &PageContent{Entry: entry, ac: ac, oa: oa, file: f}
+	if entry.Method == zip.Store && entry.Offset >= 0 {
+		pc.section = io.NewSectionReader(oa.f, entry.Offset, entry.Size)
+	}
+
+	return pc, nil
+}
+
+// Read reads page data at current position
+func (pc *PageContent) Read(p []byte) (int, error) {
+	if pc.section != nil {
+		return pc.section.Read(p)
+	}
+	if pc.pos >= pc.Entry.Size {
+		return 0, io.EOF
+	}
+
+	if pc.rc == nil || pc.rcPos > pc.pos {
+		if pc.rc != nil {
+			pc.rc.Close()
+		}
+		rc, err := pc.file.Open()
+		if err != nil {
+			return 0, err
+		}
+		pc.rc = rc
+		pc.rcPos = 0
+	}
+	if pc.rcPos < pc.pos {
+		n, err := io.CopyN(ioutil.Discard, pc.rc, pc.pos-pc.rcPos)
+		pc.rcPos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := pc.rc.Read(p)
+	pc.rcPos += int64(n)
+	pc.pos += int64(n)
+	return n, err
+}
+
+// Seek sets position of next Read
+func (pc *PageContent) Seek(offset int64, whence int) (int64, error) {
+	if pc.section != nil {
+		return pc.section.Seek(offset, whence)
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += pc.pos
+	case io.SeekEnd:
+		offset += pc.Entry.Size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	pc.pos = offset
+	return offset, nil
+}
+
+// Close releases the zip reader
+func (pc *PageContent) Close() error {
+	var err error
+	if pc.rc != nil {
+		err = pc.rc.Close()
+	}
+	pc.ac.release(pc.oa)
+	return err
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestArchive writes book with a stored page and a deflated page of the data, in a temp dir
+func newTestArchive(t *testing.T, data []byte) (string, string) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fpath := filepath.Join(dir, "book.cbz")
+
+	b := new(bytes.Buffer)
+	zw := zip.NewWriter(b)
+	for _, fh := range []*zip.FileHeader{
+		{Name: "002.jpg", Method: zip.Deflate},
+		{Name: "001.jpg", Method: zip.Store},
+		{Name: "notes.txt", Method: zip.Store},
+	} {
+		w, err := zw.CreateHeader(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fpath, b.Bytes(), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return fpath, dir
+}
+
+func TestArchiveTable(t *testing.T) {
+	fpath, dir := newTestArchive(t, []byte("page"))
+	defer os.RemoveAll(dir)
+
+	pt, err := NewArchiveCache().Table(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pt.Pages) != 2 || pt.Pages[0].Name != "001.jpg" || pt.Pages[1].Name != "002.jpg" {
+		t.Fatalf("pages %+v, want 001.jpg and 002.jpg", pt.Pages)
+	}
+	if pt.Pages[0].Method != zip.Store || pt.Pages[1].Method != zip.Deflate {
+		t.Errorf("methods %d %d, want stored then deflated", pt.Pages[0].Method, pt.Pages[1].Method)
+	}
+}
+
+func TestPageContentSeek(t *testing.T) {
+	data := make([]byte, 100000)
+	for i := range data {
+		data[i] = byte(i * 7 % 251)
+	}
+	fpath, dir := newTestArchive(t, data)
+	defer os.RemoveAll(dir)
+	ac := NewArchiveCache()
+
+	tests := []struct {
+		name   string
+		offset int64
+		whence int
+		n      int
+		pos    int64 // position of the data read
+	}{
+		{"start", 0, io.SeekStart, 10, 0},
+		{"forward", 50000, io.SeekStart, 10, 50000},
+		{"back", 10, io.SeekStart, 10, 10},
+		{"current", 100, io.SeekCurrent, 10, 120},
+		{"end", -10, io.SeekEnd, 10, 99990},
+		{"back after end", 5, io.SeekStart, 100, 5},
+		{"past end", 10, io.SeekEnd, 0, 100010},
+	}
+
+	for page, name := range []string{"stored", "deflated"} {
+		pc, err := ac.OpenContent(fpath, page+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range tests {
+			pos, err := pc.Seek(tt.offset, tt.whence)
+			if err != nil || pos != tt.pos {
+				t.Errorf("%s %s: Seek() = %d %v, want %d", name, tt.name, pos, err, tt.pos)
+				continue
+			}
+
+			buf := make([]byte, tt.n)
+			n, err := io.ReadFull(pc, buf)
+			if tt.n == 0 {
+				if m, err := pc.Read(make([]byte, 1)); m != 0 || err != io.EOF {
+					t.Errorf("%s %s: Read() = %d %v, want EOF", name, tt.name, m, err)
+				}
+				continue
+			}
+			if err != nil || !bytes.Equal(buf[:n], data[tt.pos:tt.pos+int64(tt.n)]) {
+				t.Errorf("%s %s: read %d %v, wrong data", name, tt.name, n, err)
+			}
+		}
+
+		if _, err := pc.Seek(-1, io.SeekStart); err == nil {
+			t.Errorf("%s: Seek() before start gave no error", name)
+		}
+		if err := pc.Close(); err != nil {
+			t.Errorf("%s: Close() %v", name, err)
+		}
+	}
+}
+
+func TestPageContentRange(t *testing.T) {
+	data := make([]byte, 100000)
+	for i := range data {
+		data[i] = byte(i * 7 % 251)
+	}
+	fpath, dir := newTestArchive(t, data)
+	defer os.RemoveAll(dir)
+	ac := NewArchiveCache()
+
+	tests := []struct {
+		name       string
+		rangeValue string
+		status     int
+		want       []byte
+	}{
+		{"whole", "", http.StatusOK, data},
+		{"first bytes", "bytes=0-99", http.StatusPartialContent, data[:100]},
+		{"middle", "bytes=60000-60099", http.StatusPartialContent, data[60000:60100]},
+		{"suffix", "bytes=-100", http.StatusPartialContent, data[len(data)-100:]},
+		{"open end", "bytes=99900-", http.StatusPartialContent, data[99900:]},
+		{"beyond end", "bytes=200000-", http.StatusRequestedRangeNotSatisfiable, nil},
+	}
+
+	for page, name := range []string{"stored", "deflated"} {
+		for _, tt := range tests {
+			pc, err := ac.OpenContent(fpath, page+1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest("GET", "/api/read/x/1", nil)
+			if tt.rangeValue != "" {
+				r.Header.Set("Range", tt.rangeValue)
+			}
+			w := httptest.NewRecorder()
+			http.ServeContent(w, r, pc.Entry.Name, time.Time{}, pc)
+			pc.Close()
+
+			if w.Code != tt.status {
+				t.Errorf("%s %s: status %d, want %d", name, tt.name, w.Code, tt.status)
+				continue
+			}
+			if tt.want != nil && !bytes.Equal(w.Body.Bytes(), tt.want) {
+				t.Errorf("%s %s: got %d bytes, wrong data", name, tt.name, w.Body.Len())
+			}
+		}
+	}
+}
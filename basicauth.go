@@ -0,0 +1,102 @@
+package main
+
+// HTTP Basic authentication for clients that cannot keep cookies
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BasicAuthCacheTime how long verified credentials are remembered, password hashing is too slow for every request
+var BasicAuthCacheTime = 5 * time.Minute
+
+// BasicAuthRealm realm shown in browser login prompt
+const BasicAuthRealm = "Kamishibai"
+
+// BasicAuth verifies basic auth credentials against the user accounts
+type BasicAuth struct {
+	cfg      *Config
+	guard    *LoginGuard
+	mutex    sync.Mutex
+	verified map[string]time.Time // credential digest -> verified until
+}
+
+// NewBasicAuth creates basic auth verifier, nil when basic auth is not enabled
+func NewBasicAuth(cfg *Config, guard *LoginGuard) *BasicAuth {
+	if !cfg.BasicAuth {
+		return nil
+	}
+
+	return &BasicAuth{
+		cfg:      cfg,
+		guard:    guard,
+		verified: make(map[string]time.Time),
+	}
+}
+
+// basicAuthDigest identifies credentials, includes the stored hash so password change invalidates it
+func basicAuthDigest(user *User, password string) string {
+	sum := sha256.Sum256([]byte(user.Username + "\x00" + password + "\x00" + user.Crypt))
+	return hex.EncodeToString(sum[:])
+}
+
+// User gives user of the basic auth credentials in request.
+// ok is false when no credentials were sent, user is nil when credentials are wrong or blocked
+func (ba *BasicAuth) User(r *http.Request) (user *User, ok bool) {
+	if ba == nil {
+		return nil, false
+	}
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, false
+	}
+
+	ip := clientIP(r)
+	user = ba.cfg.User(username)
+
+	// recently verified
+	if user != nil {
+		digest := basicAuthDigest(user, password)
+		ba.mutex.Lock()
+		until, found := ba.verified[digest]
+		ba.mutex.Unlock()
+		if found && until.After(time.Now()) {
+			return user, true
+		}
+	}
+
+	if ba.guard.Check(ip, username) > 0 {
+		return nil, true
+	}
+	if user == nil {
+		// still compute hash so response time dont reveal if username exists
+		user = &User{Algorithm: HashAlgorithm, Iterations: ba.cfg.Iterations}
+	}
+	if !user.CheckPassword(password) {
+		ba.guard.Failure(ip, username)
+		return nil, true
+	}
+	ba.guard.Success(ip, username)
+
+	now := time.Now()
+	ba.mutex.Lock()
+	for digest, until := range ba.verified {
+		if !until.After(now) {
+			delete(ba.verified, digest)
+		}
+	}
+	ba.verified[basicAuthDigest(user, password)] = now.Add(BasicAuthCacheTime)
+	ba.mutex.Unlock()
+
+	return user, true
+}
+
+// Challenge responds 401 asking client for basic auth credentials
+func (ba *BasicAuth) Challenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="`+BasicAuthRealm+`", charset="UTF-8"`)
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte("Unauthorised!"))
+}
@@ -8,6 +8,8 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 // Config holds server config
@@ -18,19 +20,46 @@ type Config struct {
 	PathDir      string   `json:"-"`                  // runtime value; config dir path
 	PathCache    string   `json:"-"`                  // runtime value; book cover cache dir path
 	PathDB       string   `json:"-"`                  // runtime value; db file path
-	Username     string   `json:"username"`           // username for the http authentication
-	Password     string   `json:"password,omitempty"` // one time, and it will be cleared after computed
-	Iterations   int      `json:"iterations"`         // safety, min 100,000
-	Salt         string   `json:"salt"`               // salt for the crypt
-	Crypt        string   `json:"crypt"`              // password hash
+	Username     string   `json:"username,omitempty"` // deprecated, single user login. moved to users on read
+	Password     string   `json:"password,omitempty"` // deprecated, one time, and it will be cleared after computed
+	Iterations   int      `json:"iterations"`         // password hash iterations for new hashes, min 100,000
+	Salt         string   `json:"salt,omitempty"`     // deprecated, salt for the crypt
+	Crypt        string   `json:"crypt,omitempty"`    // deprecated, password hash
 	AllowedDirs  []string `json:"allowed_dirs"`       // directory allowed to be browse
 	ImageResize  bool     `json:"image_resize"`       // resize images in reader
-	ImageQuality int      `json:"image_quality"`      // image quality for resized image
+	ImageQuality int      `json:"image_quality"`      // jpeg quality for resized image, 1-100
+	ImageFilter  string   `json:"image_filter"`       // resampling filter for resized image, nearest, bilinear, area or lanczos
+	ItemsPerPage int      `json:"items_per_page"`     // browse listing page size, when profile dont specify
+	PageCacheMB  int      `json:"page_cache_mb"`      // disk cache size of resized pages in MB, 0 for default, -1 to disable
+	Prefetch     int      `json:"prefetch_pages"`     // pages after the one read to load in background, 0 for default, -1 to disable
+
+	PersistLoginAttempts bool `json:"persist_login_attempts"` // keep failed login tracking across restart
+	BasicAuth            bool `json:"basic_auth"`             // also accept http basic auth, for clients without cookie
+
+	TLS      bool   `json:"tls"`       // serve https on port
+	TLSCert  string `json:"tls_cert"`  // certificate file, blank to generate self-signed
+	TLSKey   string `json:"tls_key"`   // private key file, blank to generate self-signed
+	HTTPPort int    `json:"http_port"` // also serve plain http on this port when tls is on, for legacy clients. 0 to disable
+
+	AllowIPs       []string `json:"allow_ips,omitempty"`       // CIDR or ip allowed to connect, blank for lan only (or all with allow_public)
+	DenyIPs        []string `json:"deny_ips,omitempty"`        // CIDR or ip refused, checked before allow_ips
+	TrustedProxies []string `json:"trusted_proxies,omitempty"` // CIDR or ip of reverse proxies trusted to set X-Forwarded-For
+	AllowPublic    bool     `json:"allow_public"`              // allow public addresses when allow_ips is blank
+
+	Users    []*User          `json:"users"`              // accounts allowed to login
+	Profiles []*DeviceProfile `json:"profiles,omitempty"` // device profiles, selectable per session
+
+	legacyUsername string       // runtime value; user migrated from single user config, inherits progress stored in db
+	mutex          sync.RWMutex // guards users
+	saveMutex      sync.Mutex   // one save at a time, so the last change is what ends up on disk
 }
 
-// ConfigHashIterations how many times the password should be hashed
+// ConfigHashIterations minimum times the password should be hashed, also what legacy hashes used
 const ConfigHashIterations = 100000
 
+// DefaultHashIterations how many times the password is hashed when config dont specify
+const DefaultHashIterations = 600000
+
 // Read read and parse configuration file
 func (cfg *Config) Read(fpath string) error {
 	byteDat, err := ioutil.ReadFile(fpath)
@@ -43,15 +72,68 @@ func (cfg *Config) Read(fpath string) error {
 		return err
 	}
 
+	needSave := false
+
 	// sanity check
 	if cfg.Port <= 0 || cfg.Port > 65535 {
 		return errors.New("invalid port number " + strconv.Itoa(cfg.Port))
 	}
-	if cfg.Crypt == "" && len(cfg.Password) < 6 {
-		return errors.New("password too short, min of 6")
+	if cfg.HTTPPort < 0 || cfg.HTTPPort > 65535 || (cfg.TLS && cfg.HTTPPort == cfg.Port) {
+		return errors.New("invalid http port number " + strconv.Itoa(cfg.HTTPPort))
+	}
+
+	// move single user login to users
+	if cfg.Username != "" {
+		if cfg.User(cfg.Username) == nil {
+			cfg.Users = append(cfg.Users, &User{
+				Username: cfg.Username,
+				Password: cfg.Password,
+				Salt:     cfg.Salt,
+				Crypt:    cfg.Crypt,
+				Role:     RoleAdmin,
+			})
+		}
+		cfg.legacyUsername = cfg.Username
+		cfg.Username = ""
+		cfg.Password = ""
+		cfg.Salt = ""
+		cfg.Crypt = ""
+		needSave = true
+	}
+
+	if len(cfg.Users) == 0 {
+		return errors.New("no user defined")
+	}
+	for _, u := range cfg.Users {
+		if u.Role == "" {
+			u.Role = RoleReader
+			needSave = true
+		}
+	}
+	// make sure someone can manage the server
+	if cfg.adminCount() == 0 {
+		cfg.Users[0].Role = RoleAdmin
+		needSave = true
+	}
+	for i, u := range cfg.Users {
+		err = u.validate()
+		if err != nil {
+			return errors.New(u.Username + ": " + err.Error())
+		}
+		for _, u2 := range cfg.Users[:i] {
+			if strings.EqualFold(u2.Username, u.Username) {
+				return errors.New(u.Username + ": " + ErrUsernameDup.Error())
+			}
+		}
+	}
+	if _, err := NewIPFilter(cfg); err != nil {
+		return err
 	}
-	if len(cfg.Username) < 3 {
-		return errors.New("username too short, min length 3")
+	for _, p := range cfg.Profiles {
+		err = p.validate()
+		if err != nil {
+			return err
+		}
 	}
 
 	// overwrite
@@ -59,17 +141,52 @@ func (cfg *Config) Read(fpath string) error {
 	cfg.PathDir = filepath.Dir(fpath)
 	cfg.PathCache = filepath.Join(cfg.PathDir, "cache")
 	cfg.PathDB = filepath.Join(cfg.PathDir, "/db.txt")
-	cfg.Iterations = ConfigHashIterations
+
+	// defaults
+	if cfg.Iterations <= 0 {
+		cfg.Iterations = DefaultHashIterations
+		needSave = true
+	}
+	if cfg.Iterations < ConfigHashIterations {
+		log.Println("iterations too low, using", ConfigHashIterations)
+		cfg.Iterations = ConfigHashIterations
+		needSave = true
+	}
+	if cfg.ImageQuality <= 0 || cfg.ImageQuality > 100 {
+		cfg.ImageQuality = DefaultImageQuality
+	}
+	if cfg.ImageFilter == "" {
+		cfg.ImageFilter = DefaultFilter
+	}
+	if !validFilter(cfg.ImageFilter) {
+		return errors.New("invalid image filter " + cfg.ImageFilter)
+	}
+	if cfg.ItemsPerPage <= 0 {
+		cfg.ItemsPerPage = ItemsPerPage
+	}
+	if cfg.PageCacheMB == 0 {
+		cfg.PageCacheMB = DefaultPageCacheSize
+	}
+	if cfg.Prefetch == 0 {
+		cfg.Prefetch = DefaultPrefetchPages
+	}
+	for _, p := range cfg.Profiles {
+		if p.ItemsPerPage <= 0 {
+			p.ItemsPerPage = cfg.ItemsPerPage
+		}
+	}
 
 	// hash password
-	if cfg.Crypt == "" {
-		// generate salt, longer because of limited character list
-		cfg.Salt = GenerateString(128)
-		// calc password hash
-		cfg.Crypt = SHA256Iter(cfg.Password, cfg.Salt, ConfigHashIterations)
-		// clear password
-		cfg.Password = ""
-		// save new cfg file
+	for _, u := range cfg.Users {
+		if u.Crypt == "" {
+			u.hashPassword(cfg.Iterations)
+			needSave = true
+		}
+	}
+
+	// save new cfg file. migrated single user config is saved after its progress is moved, see Server.Start,
+	// so the old login is still in the file if that does not finish
+	if needSave && cfg.legacyUsername == "" {
 		err := cfg.Save(cfg.PathConfig)
 		if err != nil {
 			log.Println("failed to save config file (b)")
@@ -88,21 +205,16 @@ func (cfg *Config) Read(fpath string) error {
 
 // Save save config to json file
 func (cfg *Config) Save(fpath string) error {
-	// create a copy
-	config2 := cfg
-	// clear, setup setting
-	config2.Password = ""
-	config2.Iterations = ConfigHashIterations
+	cfg.saveMutex.Lock()
+	defer cfg.saveMutex.Unlock()
 
 	// save to file
-	byteDat2, err := json.MarshalIndent(config2, "", "  ")
-	if err != nil {
-		return err
-	}
-	err = ioutil.WriteFile(fpath, byteDat2, 0644)
+	cfg.mutex.RLock()
+	byteDat2, err := json.MarshalIndent(cfg, "", "  ")
+	cfg.mutex.RUnlock()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return writeFileAtomic(fpath, byteDat2, 0600)
 }
@@ -0,0 +1,11 @@
+//go:build go1.11
+// +build go1.11
+
+package main
+
+import "net/http"
+
+// setCookieSameSite stops the cookie being sent on cross site requests, except top level navigation
+func setCookieSameSite(cki *http.Cookie) {
+	cki.SameSite = http.SameSiteLaxMode
+}
@@ -0,0 +1,9 @@
+//go:build !go1.11
+// +build !go1.11
+
+package main
+
+import "net/http"
+
+// setCookieSameSite does nothing, SameSite is not supported before go1.11
+func setCookieSameSite(cki *http.Cookie) {}
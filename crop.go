@@ -0,0 +1,120 @@
+package main
+
+// margin cropping, uniform white or black borders of scanned pages are trimmed before resizing
+
+import (
+	"image"
+)
+
+// margin crop tuning
+var (
+	CropTolerance = 24    // luma difference from border colour still counted as border
+	CropNoise     = 0.005 // fraction of pixels in a line allowed to differ, for dust and scan noise
+	CropMaxRatio  = 0.25  // most of width or height trimmed from one side
+	CropPadding   = 4     // pixels of border kept, so content does not touch the screen edge
+)
+
+// SessionCrop keyword for session, holds if reader pages are margin cropped
+var SessionCrop = "Crop"
+
+// subImager is image that can give part of itself without copying
+type subImager interface {
+	SubImage(r image.Rectangle) image.Image
+}
+
+// lineUniform checks if n pixels from offset off, step apart, are close to border colour bg
+func lineUniform(pix []uint8, off, step, n int, bg uint8) bool {
+	allowed := int(float64(n) * CropNoise)
+	bad := 0
+	for i := 0; i < n; i++ {
+		d := int(pix[off+i*step]) - int(bg)
+		if d < -CropTolerance || d > CropTolerance {
+			bad++
+			if bad > allowed {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// lineMedian gives median of n pixels from offset off, step apart
+func lineMedian(pix []uint8, off, step, n int) uint8 {
+	var hist [256]int
+	for i := 0; i < n; i++ {
+		hist[pix[off+i*step]]++
+	}
+	count := 0
+	for v, c := range hist {
+		count += c
+		if count*2 >= n {
+			return uint8(v)
+		}
+	}
+	return 0
+}
+
+// CropBounds finds the content of the image without uniform borders, in the image coordinates
+func CropBounds(m image.Image) image.Rectangle {
+	b := m.Bounds()
+	gray := grayImage(m)
+	w, h := gray.Rect.Dx(), gray.Rect.Dy()
+	if w < 3 || h < 3 {
+		return b
+	}
+	pix, stride := gray.Pix, gray.Stride
+
+	// border colour of each side is the median of its outermost line, side is kept when that line is busy
+	maxX, maxY := int(float64(w)*CropMaxRatio), int(float64(h)*CropMaxRatio)
+
+	top := 0
+	if bg := lineMedian(pix, 0, 1, w); lineUniform(pix, 0, 1, w, bg) {
+		for top < maxY && lineUniform(pix, top*stride, 1, w, bg) {
+			top++
+		}
+	}
+	bottom := 0
+	if bg := lineMedian(pix, (h-1)*stride, 1, w); lineUniform(pix, (h-1)*stride, 1, w, bg) {
+		for bottom < maxY && lineUniform(pix, (h-1-bottom)*stride, 1, w, bg) {
+			bottom++
+		}
+	}
+
+	// columns only where rows are left
+	rows := h - top - bottom
+	left := 0
+	if bg := lineMedian(pix, top*stride, stride, rows); lineUniform(pix, top*stride, stride, rows, bg) {
+		for left < maxX && lineUniform(pix, top*stride+left, stride, rows, bg) {
+			left++
+		}
+	}
+	right := 0
+	if bg := lineMedian(pix, top*stride+w-1, stride, rows); lineUniform(pix, top*stride+w-1, stride, rows, bg) {
+		for right < maxX && lineUniform(pix, top*stride+w-1-right, stride, rows, bg) {
+			right++
+		}
+	}
+
+	// keep a little of the border
+	pad := func(v int) int {
+		if v -= CropPadding; v < 0 {
+			return 0
+		}
+		return v
+	}
+
+	return image.Rect(b.Min.X+pad(left), b.Min.Y+pad(top), b.Max.X-pad(right), b.Max.Y-pad(bottom))
+}
+
+// CropMargins trims uniform borders of the image, image is given back as is when nothing to trim
+func CropMargins(m image.Image) image.Image {
+	r := CropBounds(m)
+	if r == m.Bounds() {
+		return m
+	}
+
+	if si, ok := m.(subImager); ok {
+		return si.SubImage(r)
+	}
+	return m
+}
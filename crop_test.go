@@ -0,0 +1,79 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+// newCropImage makes w x h gray page of colour bg at origin, content rectangle and dust points in colour fg
+func newCropImage(origin image.Point, w, h int, bg, fg uint8, content image.Rectangle, dust ...image.Point) *image.Gray {
+	m := image.NewGray(image.Rect(0, 0, w, h).Add(origin))
+	for i := range m.Pix {
+		m.Pix[i] = bg
+	}
+	for y := content.Min.Y; y < content.Max.Y; y++ {
+		for x := content.Min.X; x < content.Max.X; x++ {
+			m.Pix[m.PixOffset(origin.X+x, origin.Y+y)] = fg
+		}
+	}
+	for _, p := range dust {
+		m.Pix[m.PixOffset(origin.X+p.X, origin.Y+p.Y)] = fg
+	}
+	return m
+}
+
+func TestCropBounds(t *testing.T) {
+	none := image.Rectangle{}
+	odd := image.Pt(5, 7)
+	// text touching the left edge, every other pixel of the column
+	stripes := []image.Point{}
+	for y := 10; y < 70; y += 2 {
+		stripes = append(stripes, image.Pt(0, y))
+	}
+
+	tests := []struct {
+		name string
+		m    *image.Gray
+		want image.Rectangle // relative to origin of the image
+	}{
+		{"all white trims at most max ratio", newCropImage(image.Point{}, 100, 80, 255, 0, none), image.Rect(21, 16, 79, 64)},
+		{"all black trims at most max ratio", newCropImage(image.Point{}, 100, 80, 0, 255, none), image.Rect(21, 16, 79, 64)},
+		{"content on white", newCropImage(image.Point{}, 100, 80, 255, 0, image.Rect(10, 10, 90, 70)), image.Rect(6, 6, 94, 74)},
+		{"content on black", newCropImage(image.Point{}, 100, 80, 0, 255, image.Rect(10, 10, 90, 70)), image.Rect(6, 6, 94, 74)},
+		{"content at odd origin", newCropImage(odd, 100, 80, 255, 0, image.Rect(10, 10, 90, 70)), image.Rect(6, 6, 94, 74)},
+		{"solid block is border too", newCropImage(image.Point{}, 100, 80, 255, 0, image.Rect(0, 0, 100, 80)), image.Rect(21, 16, 79, 64)},
+		{"busy left edge kept", newCropImage(image.Point{}, 100, 80, 255, 0, image.Rect(10, 10, 90, 70), stripes...), image.Rect(0, 6, 94, 74)},
+		{"light gray within tolerance", newCropImage(image.Point{}, 100, 80, 255, 240, image.Rect(10, 10, 90, 70)), image.Rect(21, 16, 79, 64)},
+		{"single pixel in middle", newCropImage(image.Point{}, 100, 80, 255, 0, none, image.Pt(50, 40)), image.Rect(21, 16, 79, 64)},
+		{"single pixel near corner", newCropImage(image.Point{}, 100, 80, 255, 0, none, image.Pt(12, 12)), image.Rect(8, 8, 79, 64)},
+		{"dust in border ignored", newCropImage(image.Point{}, 400, 80, 255, 0, image.Rect(10, 10, 390, 70), image.Pt(200, 2)), image.Rect(6, 6, 394, 74)},
+		{"single pixel image", newCropImage(image.Point{}, 1, 1, 255, 0, none), image.Rect(0, 0, 1, 1)},
+		{"too narrow", newCropImage(odd, 2, 80, 255, 0, none), image.Rect(0, 0, 2, 80)},
+		{"smallest cropped", newCropImage(image.Point{}, 3, 3, 255, 0, none), image.Rect(0, 0, 3, 3)},
+	}
+
+	for _, tt := range tests {
+		want := tt.want.Add(tt.m.Rect.Min)
+		got := CropBounds(tt.m)
+		if got != want {
+			t.Errorf("%s: CropBounds() = %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+func TestCropMargins(t *testing.T) {
+	small := newCropImage(image.Point{}, 2, 2, 255, 0, image.Rectangle{})
+	if m := CropMargins(small); m != image.Image(small) {
+		t.Error("image too small to crop not given back as is")
+	}
+
+	page := newCropImage(image.Pt(5, 7), 100, 80, 255, 0, image.Rect(10, 10, 90, 70))
+	m := CropMargins(page)
+	if m.Bounds() != image.Rect(11, 13, 99, 81) {
+		t.Errorf("CropMargins() bounds %v", m.Bounds())
+	}
+	// sub-image shares the pixels
+	if g, ok := m.(*image.Gray); !ok || &g.Pix[0] != &page.Pix[page.PixOffset(11, 13)] {
+		t.Error("CropMargins() copied the image")
+	}
+}
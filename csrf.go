@@ -0,0 +1,90 @@
+package main
+
+// cross site request forgery protection, every POST must carry the token embedded by the templates
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// CSRFField form field and CSRFHeader header carrying the csrf token
+const (
+	CSRFField  = "csrf"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// CSRF makes and checks csrf tokens. token is hmac of the session id,
+// so nothing needs to be stored and it changes when session id is rotated on login
+type CSRF struct {
+	key         []byte
+	httpSession *SessionStore
+}
+
+// NewCSRF creates csrf checker, key is kept in config dir so tokens in open pages survive restart
+func NewCSRF(cfg *Config, httpSession *SessionStore) (*CSRF, error) {
+	fpath := filepath.Join(cfg.PathDir, "csrf_key")
+
+	key, err := ioutil.ReadFile(fpath)
+	if os.IsNotExist(err) || (err == nil && len(key) < 32) {
+		key = []byte(GenerateString(64))
+		err = ioutil.WriteFile(fpath, key, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &CSRF{key: key, httpSession: httpSession}, nil
+}
+
+// Token gives csrf token of the request, for embedding in forms
+func (c *CSRF) Token(w http.ResponseWriter, r *http.Request) string {
+	bind := ""
+	if _, ok := r.Context().Value(ctxKeySession).(*session); ok {
+		// request scoped session changes every request, bind to user instead
+		if user := requestUser(r); user != nil {
+			bind = "user:" + user.Username
+		}
+	} else {
+		bind = "session:" + c.httpSession.ID(w, r)
+	}
+
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(bind))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Valid checks csrf token sent with the request
+func (c *CSRF) Valid(w http.ResponseWriter, r *http.Request) bool {
+	sent := r.Header.Get(CSRFHeader)
+	if sent == "" {
+		sent = r.PostFormValue(CSRFField)
+	}
+	if sent == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(sent), []byte(c.Token(w, r))) == 1
+}
+
+// CSRFHandler is middleware to refuse POST without valid csrf token.
+// api token requests are exempt, the token is never sent by browser on its own
+func CSRFHandler(h http.Handler, c *CSRF) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, isToken := r.Context().Value(ctxKeyScope).(string)
+		if r.Method == "POST" && !isToken && !c.Valid(w, r) {
+			log.Println("invalid csrf token", clientIP(r), r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("invalid csrf token, go back and reload the page"))
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
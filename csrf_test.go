@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// newTestCSRF gives csrf checker with key in temp config dir, and a session cookie with its token
+func newTestCSRF(t *testing.T) (*CSRF, *http.Cookie, string, string) {
+	dir, err := ioutil.TempDir("", "csrf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{PathDir: dir}
+	c, err := NewCSRF(cfg, NewSessionStore(cfg))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	token := c.Token(w, httptest.NewRequest("GET", "/browse.html", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		os.RemoveAll(dir)
+		t.Fatalf("want session cookie, got %v", cookies)
+	}
+
+	return c, cookies[0], token, dir
+}
+
+func TestCSRFHandler(t *testing.T) {
+	c, cookie, token, dir := newTestCSRF(t)
+	defer os.RemoveAll(dir)
+
+	// token of another session
+	w := httptest.NewRecorder()
+	otherToken := c.Token(w, httptest.NewRequest("GET", "/browse.html", nil))
+
+	tests := []struct {
+		name   string
+		method string
+		header string // X-CSRF-Token
+		field  string // csrf form field
+		cookie bool
+		scope  string // api token scope
+		want   int
+	}{
+		{"get needs no token", "GET", "", "", true, "", http.StatusOK},
+		{"post with header", "POST", token, "", true, "", http.StatusOK},
+		{"post with form field", "POST", "", token, true, "", http.StatusOK},
+		{"post without token", "POST", "", "", true, "", http.StatusForbidden},
+		{"post with wrong token", "POST", "nottoken", "", true, "", http.StatusForbidden},
+		{"post with token of other session", "POST", otherToken, "", true, "", http.StatusForbidden},
+		{"post without session", "POST", token, "", false, "", http.StatusForbidden},
+		{"post with api token", "POST", "", "", false, ScopeRead, http.StatusOK},
+	}
+
+	h := CSRFHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), c)
+	for _, tt := range tests {
+		form := url.Values{}
+		if tt.field != "" {
+			form.Set(CSRFField, tt.field)
+		}
+		r := httptest.NewRequest(tt.method, "/api/fav", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tt.header != "" {
+			r.Header.Set(CSRFHeader, tt.header)
+		}
+		if tt.cookie {
+			r.AddCookie(cookie)
+		}
+		if tt.scope != "" {
+			r = r.WithContext(context.WithValue(r.Context(), ctxKeyScope, tt.scope))
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestCSRFKeyKept(t *testing.T) {
+	c, cookie, token, dir := newTestCSRF(t)
+	defer os.RemoveAll(dir)
+
+	// same key after restart, token of open page still valid
+	c2, err := NewCSRF(&Config{PathDir: dir}, c.httpSession)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/browse.html", nil)
+	r.AddCookie(cookie)
+	if got := c2.Token(httptest.NewRecorder(), r); got != token {
+		t.Errorf("token after reload %s, want %s", got, token)
+	}
+}
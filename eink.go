@@ -0,0 +1,128 @@
+package main
+
+// e-ink rendering, few level grayscale with dithering for old e-reader displays
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// DefaultGrayLevels gray levels of e-ink display when profile dont specify, kindle 2 and DX has 16
+const DefaultGrayLevels = 16
+
+// EInkOptions how page is converted for e-ink display
+type EInkOptions struct {
+	Levels   int     // gray levels to dither to, 2-256
+	Gamma    float64 // above 1 lightens mid tones, 1 for unchanged
+	Contrast float64 // above 1 more contrast, 1 for unchanged
+}
+
+// grayImage converts image to 8 bit grayscale, jpeg luma is used directly
+func grayImage(m image.Image) *image.Gray {
+	b := m.Bounds()
+	rect := image.Rect(0, 0, b.Dx(), b.Dy())
+
+	switch src := m.(type) {
+	case *image.Gray:
+		if b.Min == (image.Point{}) {
+			return src
+		}
+	case *image.YCbCr:
+		dst := image.NewGray(rect)
+		for y := 0; y < b.Dy(); y++ {
+			off := src.YOffset(b.Min.X, b.Min.Y+y)
+			copy(dst.Pix[y*dst.Stride:y*dst.Stride+b.Dx()], src.Y[off:off+b.Dx()])
+		}
+		return dst
+	}
+
+	dst := image.NewGray(rect)
+	draw.Draw(dst, rect, m, b.Min, draw.Src)
+	return dst
+}
+
+// toneLUT builds lookup table of gamma and contrast adjustment
+func toneLUT(gamma, contrast float64) [256]uint8 {
+	if gamma <= 0 {
+		gamma = 1
+	}
+	if contrast <= 0 {
+		contrast = 1
+	}
+
+	var lut [256]uint8
+	for i := range lut {
+		v := 255 * math.Pow(float64(i)/255, 1/gamma)
+		v = (v-127.5)*contrast + 127.5
+		lut[i] = clampUint8(float32(v))
+	}
+	return lut
+}
+
+// grayPalette is palette of n evenly spaced grays, black to white
+func grayPalette(n int) color.Palette {
+	p := make(color.Palette, n)
+	for i := range p {
+		p[i] = color.Gray{uint8(i * 255 / (n - 1))}
+	}
+	return p
+}
+
+// grayToPaletted converts gray image to 256 gray palette image, so gif keeps the grays
+func grayToPaletted(g *image.Gray) *image.Paletted {
+	b := g.Bounds()
+	p := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), grayPalette(256))
+	for y := 0; y < b.Dy(); y++ {
+		copy(p.Pix[y*p.Stride:y*p.Stride+b.Dx()], g.Pix[g.PixOffset(b.Min.X, b.Min.Y+y):])
+	}
+	return p
+}
+
+// EInkImage converts image to gray levels of the e-ink display, Floyd–Steinberg dithered.
+// result is paletted so png and gif are encoded with as few bits as possible
+func EInkImage(m image.Image, opt EInkOptions) *image.Paletted {
+	levels := opt.Levels
+	if levels < 2 || levels > 256 {
+		levels = DefaultGrayLevels
+	}
+
+	gray := grayImage(m)
+	lut := toneLUT(opt.Gamma, opt.Contrast)
+	w, h := gray.Rect.Dx(), gray.Rect.Dy()
+
+	dst := image.NewPaletted(image.Rect(0, 0, w, h), grayPalette(levels))
+	step := 255.0 / float32(levels-1)
+
+	// error of current and next row, with a pixel of padding each side
+	cur := make([]float32, w+2)
+	next := make([]float32, w+2)
+	for y := 0; y < h; y++ {
+		row := gray.Pix[y*gray.Stride:]
+		out := dst.Pix[y*dst.Stride:]
+		for x := 0; x < w; x++ {
+			v := float32(lut[row[x]]) + cur[x+1]
+			idx := int(v/step + 0.5)
+			if idx < 0 {
+				idx = 0
+			}
+			if idx > levels-1 {
+				idx = levels - 1
+			}
+			out[x] = uint8(idx)
+
+			e := v - float32(idx)*step
+			cur[x+2] += e * 7 / 16
+			next[x] += e * 3 / 16
+			next[x+1] += e * 5 / 16
+			next[x+2] += e * 1 / 16
+		}
+		cur, next = next, cur
+		for i := range next {
+			next[i] = 0
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestGrayPalette(t *testing.T) {
+	for _, n := range []int{2, 4, 16, 256} {
+		p := grayPalette(n)
+		if len(p) != n {
+			t.Errorf("grayPalette(%d) has %d colors", n, len(p))
+			continue
+		}
+		if p[0] != (color.Gray{0}) || p[n-1] != (color.Gray{255}) {
+			t.Errorf("grayPalette(%d) from %v to %v, want black to white", n, p[0], p[n-1])
+		}
+		for i := 1; i < n; i++ {
+			if p[i].(color.Gray).Y <= p[i-1].(color.Gray).Y {
+				t.Errorf("grayPalette(%d) not increasing at %d", n, i)
+				break
+			}
+		}
+	}
+}
+
+func TestToneLUT(t *testing.T) {
+	tests := []struct {
+		name            string
+		gamma, contrast float64
+		in, want        uint8
+	}{
+		{"unchanged", 1, 1, 100, 100},
+		{"invalid gives unchanged", 0, -1, 100, 100},
+		{"gamma lightens mid tone", 2, 1, 64, 128},
+		{"gamma keeps black", 2, 1, 0, 0},
+		{"gamma keeps white", 2, 1, 255, 255},
+		{"contrast clamps black", 1, 2, 5, 0},
+		{"contrast", 1, 2, 96, 64},
+		{"contrast clamps white", 1, 2, 250, 255},
+	}
+
+	for _, tt := range tests {
+		lut := toneLUT(tt.gamma, tt.contrast)
+		got := lut[tt.in]
+		if d := int(got) - int(tt.want); d < -1 || d > 1 {
+			t.Errorf("%s: toneLUT(%g, %g)[%d] = %d, want %d", tt.name, tt.gamma, tt.contrast, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEInkImage(t *testing.T) {
+	tests := []struct {
+		name   string
+		levels int
+		want   int // palette size
+		gray   uint8
+	}{
+		{"black and white", 2, 2, 128},
+		{"four levels", 4, 4, 100},
+		{"kindle levels", 16, 16, 77},
+		{"all levels", 256, 256, 200},
+		{"default levels", 0, DefaultGrayLevels, 128},
+		{"too many levels", 300, DefaultGrayLevels, 128},
+		{"black", 4, 4, 0},
+		{"white", 4, 4, 255},
+	}
+
+	for _, tt := range tests {
+		// sub-image with odd origin
+		src := image.NewGray(image.Rect(0, 0, 41, 31))
+		for i := range src.Pix {
+			src.Pix[i] = tt.gray
+		}
+		m := src.SubImage(image.Rect(3, 5, 40, 30))
+
+		dst := EInkImage(m, EInkOptions{Levels: tt.levels, Gamma: 1, Contrast: 1})
+		if dst.Bounds() != image.Rect(0, 0, 37, 25) {
+			t.Errorf("%s: bounds %v", tt.name, dst.Bounds())
+		}
+		if len(dst.Palette) != tt.want {
+			t.Errorf("%s: %d colors, want %d", tt.name, len(dst.Palette), tt.want)
+			continue
+		}
+
+		// dither must stay in palette and keep the average brightness
+		sum := 0.0
+		for _, idx := range dst.Pix {
+			if int(idx) >= tt.want {
+				t.Errorf("%s: pixel index %d out of palette", tt.name, idx)
+				break
+			}
+			sum += float64(dst.Palette[idx].(color.Gray).Y)
+		}
+		avg := sum / float64(len(dst.Pix))
+		if math.Abs(avg-float64(tt.gray)) > 3 {
+			t.Errorf("%s: average gray %.1f, want %d", tt.name, avg, tt.gray)
+		}
+	}
+}
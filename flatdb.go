@@ -3,12 +3,10 @@ package main
 // flat file db
 
 import (
-	"archive/zip"
 	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"math/rand"
@@ -33,6 +31,13 @@ const FlatDBCharsFSize = "%010d"
 // FlatDBCharsEpoch is number of character reserved for the epoch time
 const FlatDBCharsEpoch = "%010d"
 
+// book file conditions, see Book.Cond
+const (
+	BookCondUnknown int64 = 0
+	BookCondExists  int64 = 1
+	BookCondMissing int64 = 2
+)
+
 // RegexSupportedImageExt supported image extension
 var RegexSupportedImageExt = regexp.MustCompile(`(?i)\.(jpg|jpeg|gif|png)$`)
 
@@ -117,6 +122,7 @@ type FlatDB struct {
 	mapperPath   map[string]*Book   // map books by file path (unique)
 	mapperTitle  map[string][]*Book // group books by title (array)
 	mapperAuthor map[string][]*Book // group books by author (array)
+	index        *BookIndex         // search index on author and title
 	Path         string             // where the database is stored
 	FileModDate  int64              // file last modified date
 }
@@ -152,12 +158,12 @@ func genChar(minLen int) string {
 func bookCond(fp string) int64 {
 	_, err := os.Stat(fp)
 	if err == nil {
-		return 1
+		return BookCondExists
 	}
 	if os.IsNotExist(err) {
-		return 2
+		return BookCondMissing
 	}
-	return 0
+	return BookCondUnknown
 }
 
 // New initialize new Flat Database
@@ -169,6 +175,7 @@ func (db *FlatDB) New(dbPath string) {
 	db.mapperPath = make(map[string]*Book)
 	db.mapperTitle = make(map[string][]*Book)
 	db.mapperAuthor = make(map[string][]*Book)
+	db.index = NewBookIndex()
 }
 
 // Clear all data
@@ -184,6 +191,7 @@ func (db *FlatDB) Clear() {
 	db.mapperPath = make(map[string]*Book)
 	db.mapperTitle = make(map[string][]*Book)
 	db.mapperAuthor = make(map[string][]*Book)
+	db.index = NewBookIndex()
 }
 
 // Load data using default file path
@@ -214,9 +222,6 @@ func (db *FlatDB) Import(dbPath string) error {
 
 	scanner := bufio.NewScanner(file)
 	var prevLen uint64
-	var books []*Book
-	var ibooks []*IBook
-	var mu sync.Mutex
 
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -241,13 +246,7 @@ func (db *FlatDB) Import(dbPath string) error {
 		}
 
 		db.mutex.Lock()
-		db.books = append(db.books, book)
-		db.ibooks = append(db.ibooks, ibook)
-		db.mapperID[book.ID] = book
-		db.mapperIID[book.ID] = ibook
-		db.mapperPath[book.Fullpath] = book
-		db.mapperTitle[book.Title] = append(db.mapperTitle[book.Title], book)
-		db.mapperAuthor[book.Author] = append(db.mapperAuthor[book.Author], book)
+		db.insert(ibook)
 		db.mutex.Unlock()
 
 		prevLen += uint64(len(line) + 1)
@@ -257,16 +256,23 @@ func (db *FlatDB) Import(dbPath string) error {
 		return err
 	}
 
-	// Update the database with the batched operations
-	mu.Lock()
-	db.books = append(db.books, books...)
-	db.ibooks = append(db.ibooks, ibooks...)
-	// Update other mapper fields
-	mu.Unlock()
-
 	return nil
 }
 
+// insert adds book into memory and search index, caller must hold the lock
+func (db *FlatDB) insert(ibook *IBook) {
+	book := ibook.Book
+
+	db.books = append(db.books, book)
+	db.ibooks = append(db.ibooks, ibook)
+	db.mapperID[book.ID] = book
+	db.mapperIID[book.ID] = ibook
+	db.mapperPath[book.Fullpath] = book
+	db.mapperTitle[book.Title] = append(db.mapperTitle[book.Title], book)
+	db.mapperAuthor[book.Author] = append(db.mapperAuthor[book.Author], book)
+	db.index.Add(book)
+}
+
 func (db *FlatDB) Save() {
 	db.Export(db.Path)
 }
@@ -398,6 +404,89 @@ func (db *FlatDB) UpdateFav(id string, fav bool) (int, error) {
 	return b2len, err
 }
 
+// UpdateMeta change book title, author and number, the whole db file is rewritten
+func (db *FlatDB) UpdateMeta(id, title, author, number string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	ibook := db.mapperIID[id]
+	if ibook == nil {
+		return ErrNilIBook
+	}
+
+	// replace with copy, book in use by other requests are not changed half way
+	book := *ibook.Book
+	book.Title = title
+	book.Author = author
+	book.Number = number
+	ibook.Book = &book
+
+	return db.rewrite()
+}
+
+// DeleteBook removes book record, the whole db file is rewritten. book file is not touched
+func (db *FlatDB) DeleteBook(id string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.mapperIID[id] == nil {
+		return ErrNilIBook
+	}
+
+	ibooks := []*IBook{}
+	for _, ibook := range db.ibooks {
+		if ibook.ID != id {
+			ibooks = append(ibooks, ibook)
+		}
+	}
+	db.ibooks = ibooks
+
+	return db.rewrite()
+}
+
+// rewrite saves all records to db file and rebuild memory from db.ibooks, caller must hold the lock
+func (db *FlatDB) rewrite() error {
+	buf := new(bytes.Buffer)
+	var prevLen uint64
+	for _, ibook := range db.ibooks {
+		b := bookToCSV(ibook.Book)
+		buf.Write(b)
+
+		// record byte position has shifted
+		ibook.Address = prevLen
+		ibook.Length = uint64(len(b) - 1)
+		prevLen += uint64(len(b))
+	}
+	err := writeFileAtomic(db.Path, buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+
+	// rebuild memory
+	ibooks := db.ibooks
+	db.books = nil
+	db.ibooks = nil
+	db.mapperID = make(map[string]*Book)
+	db.mapperIID = make(map[string]*IBook)
+	db.mapperPath = make(map[string]*Book)
+	db.mapperTitle = make(map[string][]*Book)
+	db.mapperAuthor = make(map[string][]*Book)
+	db.index = NewBookIndex()
+	for _, ibook := range ibooks {
+		db.insert(ibook)
+	}
+
+	return nil
+}
+
+// Len number of books in db
+func (db *FlatDB) Len() int {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	return len(db.ibooks)
+}
+
 // BookIDs gives list of all the book ids in the db
 func (db *FlatDB) BookIDs() []string {
 	ids := make([]string, len(db.ibooks))
@@ -409,7 +498,7 @@ func (db *FlatDB) BookIDs() []string {
 	return ids
 }
 
-// AddBook by file path, returns generated book id. return non-pointer book because db will be reloaded
+// AddBook by file path, returns newly added book
 func (db *FlatDB) AddBook(bookPath string) (*Book, error) {
 	// generate unique book id
 	id := genChar(3)
@@ -451,19 +540,34 @@ func (db *FlatDB) AddBook(bookPath string) (*Book, error) {
 		Itime: time.Now().Unix(),
 	}
 
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
 	f, err := os.OpenFile(db.Path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
+	// record starts at the end of db file
+	dbStat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
 	// save to db file
 	b := bookToCSV(&book)
-	f.Write(b)
-	f.Close()
+	_, err = f.Write(b)
+	if err != nil {
+		return nil, err
+	}
 
-	// reload from db file
-	db.Reload()
+	// add to memory instead of reloading whole db, so index dont need to be rebuilt
+	db.insert(&IBook{
+		Book:    &book,
+		Address: uint64(dbStat.Size()),
+		Length:  uint64(len(b) - 1), // without line break
+	})
 
 	return &book, nil
 }
@@ -512,7 +616,8 @@ func (db *FlatDB) AddFile(fpath string) (*Book, error) {
 	// make sure books are unique so no duplicate db record
 	book := db.GetBookByPath(fpath)
 	if book != nil {
-		// skip
+		// file is there, in case it was marked missing before
+		db.MarkFound(book.ID)
 		return nil, ErrDupBook
 	}
 
@@ -661,27 +766,16 @@ func getNumber(str string) string {
 
 // cbzGetPages find out how many pages in cbz
 func cbzGetPages(fp string) (int64, error) {
-	zr, err := zip.OpenReader(fp)
+	pt, err := archives.Table(fp)
 	if err != nil {
 		return -1, err
 	}
-	defer zr.Close()
-
-	var i int64
-	for _, f := range zr.File {
-		if RegexSupportedImageExt.MatchString(f.Name) {
-			i++
-		}
-	}
 
-	// force free memory with GC
-	zr = nil
-
-	if i == 0 {
+	if len(pt.Pages) == 0 {
 		return -1, ErrNotBook
 	}
 
-	return i, nil
+	return int64(len(pt.Pages)), nil
 }
 
 // GetBookByID get Book object by book id
@@ -707,41 +801,12 @@ func (db *FlatDB) GetPageCoverByID(bookID string) ([]byte, error) {
 		return nil, ErrNotBook
 	}
 
-	zr, err := zip.OpenReader(book.Fullpath)
+	// first image in reading order
+	rc, err := archives.OpenPage(book.Fullpath, 1)
 	if err != nil {
 		return nil, err
 	}
-	defer zr.Close()
-
-	// get zip file list
-	files := []string{}
-	for _, f := range zr.File {
-		if !RegexSupportedImageExt.MatchString(f.Name) {
-			continue
-		}
-
-		files = append(files, f.Name)
-	}
-
-	// do natural sort
-	files = sortNatural(files, RegexSupportedImageExt)
-
-	// get first image file
-	var rc io.ReadCloser
-	for _, f := range zr.File {
-		if f.Name != files[0] {
-			continue
-		}
-
-		// get image data
-		rc, err = f.Open()
-		if err != nil {
-			rc.Close()
-			return nil, err
-		}
-		defer rc.Close()
-		break
-	}
+	defer rc.Close()
 
 	// generate thumb
 	imgDat, err := ImageThumb(rc)
@@ -771,14 +836,64 @@ func (db *FlatDB) SearchBookByNameAndSize(fname string, size int64) []*Book {
 	return books
 }
 
-// Search find Books base on title and author
+// Search find Books base on title and author, ordered by match quality
 func (db *FlatDB) Search(search string) []*Book {
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
 
-	books := filterBooksByAuthorTitle(db.books, search)
+	return db.index.Search(search)
+}
 
-	return books
+// MarkMissing flag book file as no longer exists, so it can be skipped without checking the disk again
+func (db *FlatDB) MarkMissing(bookID string) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	book := db.mapperID[bookID]
+	if book != nil && book.Cond != BookCondMissing {
+		db.replace(bookID, func(b *Book) { b.Cond = BookCondMissing })
+	}
+}
+
+// MarkFound flag book file as exists again, e.g. drive mounted back, after it was marked missing
+func (db *FlatDB) MarkFound(bookID string) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	book := db.mapperID[bookID]
+	if book != nil && book.Cond == BookCondMissing {
+		db.replace(bookID, func(b *Book) { b.Cond = BookCondExists })
+	}
+}
+
+// replace swaps in changed copy of the book, readers holding the old pointer without the lock keep a consistent book.
+// title and author must not be changed by fn, caller must hold the lock
+func (db *FlatDB) replace(bookID string, fn func(*Book)) {
+	ibook := db.mapperIID[bookID]
+	if ibook == nil {
+		return
+	}
+	old := ibook.Book
+	book := *old
+	fn(&book)
+
+	ibook.Book = &book
+	db.mapperID[bookID] = &book
+	db.mapperPath[book.Fullpath] = &book
+	replaceBookPtr(db.books, old, &book)
+	replaceBookPtr(db.mapperTitle[book.Title], old, &book)
+	replaceBookPtr(db.mapperAuthor[book.Author], old, &book)
+	db.index.Replace(&book)
+}
+
+// replaceBookPtr replaces old book pointer in the list with new one
+func replaceBookPtr(books []*Book, old, book *Book) {
+	for i, b := range books {
+		if b == old {
+			books[i] = book
+			return
+		}
+	}
 }
 
 //
@@ -803,7 +918,7 @@ func csvToBook(line string) (*Book, error) {
 		Author:   records[12],
 		Number:   records[13],
 		Fullpath: records[14],
-		Cond:     bookCond(records[1]),
+		Cond:     mustInt64(records[1]),
 		Pages:    mustInt64(records[2]),
 		Page:     mustInt64(records[3]),
 		Ranking:  mustInt64(records[4]),
@@ -820,9 +935,6 @@ func csvToBook(line string) (*Book, error) {
 
 // bookToCSV convert Book to csv bytes
 func bookToCSV(book *Book) []byte {
-	// book file name
-	fname := path.Base(book.Fullpath)
-
 	// DO NOT change ordering, can only append in future
 	// use this a reference, book.XX
 	records := []string{
@@ -837,9 +949,9 @@ func bookToCSV(book *Book) []byte {
 		fmt.Sprintf(FlatDBCharsEpoch, book.Mtime), //  8  Mtime
 		fmt.Sprintf(FlatDBCharsEpoch, book.Itime), //  9  Itime
 		fmt.Sprintf(FlatDBCharsEpoch, book.Rtime), // 10  Rtime
-		getTitle(fname),                           // 11  Title
-		getAuthor(fname),                          // 12  Author
-		getNumber(fname),                          // 13  Number
+		book.Title,                                // 11  Title
+		book.Author,                               // 12  Author
+		book.Number,                               // 13  Number
 		book.Fullpath,                             // 14  Fullpath
 	}
 
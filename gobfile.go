@@ -0,0 +1,57 @@
+package main
+
+// state kept in memory and saved to file as gob from time to time, e.g. sessions and login attempts
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sync"
+)
+
+// gobFile saves state of its owner to file as gob. changes are counted under the owner lock,
+// a save marks saved only the changes it has written, so changes made while writing stay pending
+type gobFile struct {
+	path      string     // file to save to, blank for in-memory only
+	changes   uint64     // bumped on every change of the state
+	saved     uint64     // changes already on disk
+	saveMutex sync.Mutex // one save at a time
+}
+
+// changed counts a change of the state, caller holds the owner lock
+func (gf *gobFile) changed() {
+	gf.changes++
+}
+
+// dirty has the state changed since last save, caller holds the owner lock
+func (gf *gobFile) dirty() bool {
+	return gf.changes != gf.saved
+}
+
+// save encodes the state with encode while holding lock, then writes it to file
+func (gf *gobFile) save(lock sync.Locker, encode func(enc *gob.Encoder) error) error {
+	if gf.path == "" {
+		return nil
+	}
+
+	gf.saveMutex.Lock()
+	defer gf.saveMutex.Unlock()
+
+	b := new(bytes.Buffer)
+	lock.Lock()
+	err := encode(gob.NewEncoder(b))
+	changes := gf.changes
+	lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomic(gf.path, b.Bytes(), 0600)
+	if err != nil {
+		return err
+	}
+
+	lock.Lock()
+	gf.saved = changes
+	lock.Unlock()
+	return nil
+}
@@ -28,14 +28,20 @@ func getPage(httpSession *SessionStore, cfg *Config, handler http.Handler) func(
 		}
 
 		// not logged in, show login page
-		value := httpSession.Get(w, r, LoggedIn)
-		if value != true {
+		user := requestUser(r)
+		if user == nil {
 			rawquery := base64.URLEncoding.EncodeToString([]byte(r.URL.RawQuery))
 
 			http.Redirect(w, r, "/login.html?referer="+r.URL.Path+"&rawquery="+rawquery, http.StatusFound)
 			return
 		}
 
+		if !roleAllowed(user, r) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Forbidden!"))
+			return
+		}
+
 		// take referer page if provided
 		qry := r.URL.Query()
 		referer := qry.Get("referer")
@@ -69,14 +75,6 @@ var funcMapBrowse = template.FuncMap{
 		}
 		return rr
 	},
-	"browsePageN": func(a, b int) int {
-		// browse, next or previous listing page
-		c := a + b
-		if c < 1 {
-			return 1
-		}
-		return c
-	},
 	"readPageN": func(bk Book, a int) int {
 		// readin, for jumping pages
 		b := int(bk.Page) + a
@@ -88,6 +86,10 @@ var funcMapBrowse = template.FuncMap{
 		}
 		return b
 	},
+	"mb": func(n int64) string {
+		// admin, bytes in MB
+		return fmt.Sprintf("%.1f", float64(n)/(1<<20))
+	},
 }
 
 // prepare templates at start up
@@ -97,6 +99,9 @@ var (
 	tmplBrowseLegacy = template.Must(gtmpl.New("browseLegacy").Parse(string(mustRead("ssp/legacy.html"))))
 	tmplLogin        = template.Must(gtmpl.New("login").Parse(string(mustRead("ssp/login.html"))))
 	tmplRead         = template.Must(gtmpl.New("read").Parse(string(mustRead("ssp/read.html"))))
+	tmplAdmin        = template.Must(gtmpl.New("admin").Parse(string(mustRead("ssp/admin.html"))))
+	tmplAccount      = template.Must(gtmpl.New("account").Parse(string(mustRead("ssp/account.html"))))
+	tmplSettings     = template.Must(gtmpl.New("settings").Parse(string(mustRead("ssp/settings.html"))))
 )
 
 func mustRead(filepath string) []byte {
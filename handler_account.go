@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// accountGet http GET account page, lists sessions and api tokens of the user
+func accountGet(cfg *Config, httpSession *SessionStore, csrf *CSRF, tmpl *template.Template) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		renderAccount(w, r, cfg, httpSession, csrf, tmpl, r.URL.Query().Get("msg"), "")
+	}
+}
+
+// renderAccount renders account page, newToken is shown once after creating api token
+func renderAccount(w http.ResponseWriter, r *http.Request, cfg *Config, httpSession *SessionStore, csrf *CSRF, tmpl *template.Template, msg, newToken string) {
+	// latest copy, tokens could have changed during the request
+	user := cfg.User(requestUser(r).Username)
+	if user == nil {
+		responseError(w, ErrUserNotFound)
+		return
+	}
+
+	// account template
+	data := struct {
+		User     *User
+		Sessions []SessionInfo
+		Scopes   []string
+		Message  string
+		NewToken string
+		CSRF     string
+	}{
+		User:     user,
+		Sessions: httpSession.UserSessions(w, r, user.Username),
+		Scopes:   []string{ScopeRead, ScopeWrite},
+		Message:  msg,
+		NewToken: newToken,
+		CSRF:     csrf.Token(w, r),
+	}
+
+	// exec template
+	buf := bytes.Buffer{}
+	err := tmpl.Execute(&buf, data)
+	if err != nil {
+		responseError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(buf.String()))
+}
+
+// accountRedirect go back to account page showing the message
+func accountRedirect(w http.ResponseWriter, r *http.Request, msg string) {
+	http.Redirect(w, r, "/account.html?msg="+url.QueryEscape(msg), http.StatusSeeOther)
+}
+
+// accountSessionPOST http POST revoke a session or all other sessions of the user
+func accountSessionPOST(httpSession *SessionStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		err := r.ParseForm()
+		if err != nil {
+			responseBadRequest(w, errors.New("cannot parse form data"))
+			return
+		}
+
+		user := requestUser(r)
+
+		switch action := r.Form.Get("action"); action {
+		case "revoke":
+			if !httpSession.Revoke(user.Username, r.Form.Get("session")) {
+				accountRedirect(w, r, "session not found")
+				return
+			}
+			log.Println("revoked session of", user.Username)
+			accountRedirect(w, r, "session revoked")
+		case "others":
+			n := httpSession.RevokeOthers(w, r, user.Username)
+			log.Println("revoked", n, "other sessions of", user.Username)
+			accountRedirect(w, r, strconv.Itoa(n)+" other sessions revoked")
+		default:
+			accountRedirect(w, r, "unknown action "+action)
+		}
+	}
+}
+
+// accountTokenPOST http POST create or revoke api token of the user
+func accountTokenPOST(cfg *Config, httpSession *SessionStore, csrf *CSRF, tmpl *template.Template) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		err := r.ParseForm()
+		if err != nil {
+			responseBadRequest(w, errors.New("cannot parse form data"))
+			return
+		}
+
+		user := requestUser(r)
+
+		switch action := r.Form.Get("action"); action {
+		case "create":
+			name := r.Form.Get("name")
+			token, err := cfg.AddToken(user.Username, name, r.Form.Get("scope"))
+			if err != nil {
+				accountRedirect(w, r, "failed to create token: "+err.Error())
+				return
+			}
+			log.Println("created api token", name, "of", user.Username)
+			// render directly, token must not end up in url
+			renderAccount(w, r, cfg, httpSession, csrf, tmpl, "token "+name+" created, copy it now, it will not be shown again", token)
+		case "revoke":
+			id := r.Form.Get("token")
+			err := cfg.RevokeToken(user.Username, id)
+			if err != nil {
+				accountRedirect(w, r, "failed to revoke token: "+err.Error())
+				return
+			}
+			log.Println("revoked api token", id, "of", user.Username)
+			accountRedirect(w, r, "token revoked")
+		default:
+			accountRedirect(w, r, "unknown action "+action)
+		}
+	}
+}
+
+// logoutPOST http POST logout, ends current session
+func logoutPOST(httpSession *SessionStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if user := requestUser(r); user != nil {
+			log.Println("logged out", user.Username)
+		}
+		httpSession.Delete(w, r)
+
+		http.Redirect(w, r, "/login.html", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// rescanning is set while library rescan is running, stops multiple rescan at the same time
+var rescanning int32
+
+// adminGet http GET admin page, manage users and library
+func adminGet(cfg *Config, db *FlatDB, guard *LoginGuard, pageCache *PageCache, csrf *CSRF, tmpl *template.Template) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		query := r.URL.Query()
+
+		// admin template
+		data := struct {
+			Users       []*User
+			Roles       []string
+			AllowedDirs []string
+			Rescanning  bool
+			Books       int
+			Book        *Book
+			Message     string
+			Blocked     []LoginAttempt
+			PageCache   *PageCacheStats
+			CSRF        string
+		}{
+			Users:       cfg.UserList(),
+			Roles:       []string{RoleAdmin, RoleReader, RoleGuest},
+			AllowedDirs: cfg.AllowedDirs,
+			Rescanning:  atomic.LoadInt32(&rescanning) == 1,
+			Books:       db.Len(),
+			Message:     query.Get("msg"),
+			Blocked:     guard.Blocked(),
+			CSRF:        csrf.Token(w, r),
+		}
+
+		if pageCache != nil {
+			stats := pageCache.Stats()
+			data.PageCache = &stats
+		}
+
+		// book to edit
+		bookID := query.Get("book")
+		if bookID != "" {
+			data.Book = db.GetBookByID(bookID)
+			if data.Book == nil {
+				data.Message = "book not found " + bookID
+			}
+		}
+
+		// exec template
+		buf := bytes.Buffer{}
+		err := tmpl.Execute(&buf, data)
+		if err != nil {
+			responseError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(buf.String()))
+	}
+}
+
+// adminRedirect go back to admin page showing the message
+func adminRedirect(w http.ResponseWriter, r *http.Request, msg string) {
+	http.Redirect(w, r, "/admin.html?msg="+url.QueryEscape(msg), http.StatusSeeOther)
+}
+
+// adminUserPOST http POST add, update or delete user
+func adminUserPOST(cfg *Config, progress *ProgressStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		err := r.ParseForm()
+		if err != nil {
+			responseBadRequest(w, errors.New("cannot parse form data"))
+			return
+		}
+
+		action := r.Form.Get("action")
+		username := r.Form.Get("username")
+		password := r.Form.Get("password")
+		role := r.Form.Get("role")
+		dirs := StringSliceFlatten(strings.Split(r.Form.Get("allowed_dirs"), "\n"))
+
+		switch action {
+		case "add":
+			err = cfg.AddUser(&User{
+				Username:    username,
+				Password:    password,
+				Role:        role,
+				AllowedDirs: dirs,
+			})
+		case "update":
+			err = cfg.UpdateUser(username, func(u *User) {
+				u.Role = role
+				u.AllowedDirs = dirs
+				// blank keeps current password
+				u.Password = password
+			})
+		case "delete":
+			err = cfg.DeleteUser(username)
+			if err == nil {
+				// user created again later starts afresh
+				err = progress.Remove(username)
+			}
+		default:
+			err = errors.New("unknown action " + action)
+		}
+		if err != nil {
+			adminRedirect(w, r, "failed to "+action+" user "+username+": "+err.Error())
+			return
+		}
+
+		log.Println("admin", requestUser(r).Username, action, "user", username)
+		adminRedirect(w, r, action+" user "+username)
+	}
+}
+
+// adminRescanPOST http POST rescan allowed dirs for new books in background
+func adminRescanPOST(cfg *Config, db *FlatDB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if !atomic.CompareAndSwapInt32(&rescanning, 0, 1) {
+			adminRedirect(w, r, "rescan already running")
+			return
+		}
+
+		go func() {
+			defer atomic.StoreInt32(&rescanning, 0)
+			loadDirs(db, cfg.AllowedDirs)
+		}()
+
+		adminRedirect(w, r, "rescan started")
+	}
+}
+
+// adminBookPOST http POST edit book metadata or delete book
+func adminBookPOST(cfg *Config, db *FlatDB, pageCache *PageCache) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		err := r.ParseForm()
+		if err != nil {
+			responseBadRequest(w, errors.New("cannot parse form data"))
+			return
+		}
+
+		action := r.Form.Get("action")
+		bookID := r.Form.Get("book")
+
+		book := db.GetBookByID(bookID)
+		if book == nil {
+			adminRedirect(w, r, "book not found "+bookID)
+			return
+		}
+
+		switch action {
+		case "edit":
+			err = db.UpdateMeta(bookID,
+				strings.TrimSpace(r.Form.Get("title")),
+				strings.TrimSpace(r.Form.Get("author")),
+				strings.TrimSpace(r.Form.Get("number")))
+		case "delete":
+			err = db.DeleteBook(bookID)
+			if err == nil {
+				// cached thumbnail and pages no longer needed
+				os.Remove(filepath.Join(cfg.PathCache, bookID+".jpg"))
+				if pageCache != nil {
+					pageCache.Invalidate(bookID)
+				}
+			}
+			if err == nil && r.Form.Get("file") == "1" {
+				archives.Forget(book.Fullpath)
+				err = os.Remove(book.Fullpath)
+			}
+		default:
+			err = errors.New("unknown action " + action)
+		}
+		if err != nil {
+			adminRedirect(w, r, "failed to "+action+" book "+bookID+": "+err.Error())
+			return
+		}
+
+		log.Println("admin", requestUser(r).Username, action, "book", bookID, book.Fullpath)
+		adminRedirect(w, r, action+" book "+filepath.Base(book.Fullpath))
+	}
+}
+
+// adminUnblockPOST http POST clear failed login of blocked ip or username
+func adminUnblockPOST(guard *LoginGuard) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		err := r.ParseForm()
+		if err != nil {
+			responseBadRequest(w, errors.New("cannot parse form data"))
+			return
+		}
+
+		key := r.Form.Get("key")
+		guard.Unblock(key)
+
+		log.Println("admin", requestUser(r).Username, "unblock", key)
+		adminRedirect(w, r, "unblock "+key)
+	}
+}
+
+// adminCachePOST http POST remove all cached pages
+func adminCachePOST(pageCache *PageCache) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if pageCache == nil {
+			adminRedirect(w, r, "page cache is disabled")
+			return
+		}
+
+		pageCache.Clear()
+
+		log.Println("admin", requestUser(r).Username, "clear page cache")
+		adminRedirect(w, r, "cleared page cache")
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"strconv"
 	"strings"
 	"time"
-	"fmt"
 )
 
 // FileList contains list of files and folders
@@ -28,12 +27,9 @@ type FileInfoBasic struct {
 	Book              // not using pointer so can manipulate if necessary
 }
 
-// ItemsPerPage use for pagination
+// ItemsPerPage default page size for pagination, used when config or profile dont specify
 var ItemsPerPage = 23
 
-// SortMaxSize maximum allowed size for sorting, otherwise it will skip sort
-//var SortMaxSize = 300
-
 // special path that is used for special condition for using non-dir path
 type specialPath string
 
@@ -42,8 +38,8 @@ const (
 	specialPathHistory           specialPath = "__history__"
 	specialPathHistoryFinished   specialPath = "__history_finished__"
 	specialPathHistoryUnfinished specialPath = "__history_unfinished__"
-	specialPathFav		     specialPath = "__fav__"
-	specialPathFavAll	     specialPath = "__favall__"
+	specialPathFav               specialPath = "__fav__"
+	specialPathFavAll            specialPath = "__favall__"
 )
 
 func isSpecialPath(dirPath string) bool {
@@ -59,22 +55,22 @@ func isSpecialPath(dirPath string) bool {
 	return false
 }
 
-const (
-	sortOrderByFileName    = "name"
-	sortOrderByFileModTime = "time"
-	sortOrderByReadTime    = "read"
-	sortOrderByAuthor      = "author"
-	sortOrderByFav         = "fav"
-)
-
 // browseGet http GET lists the folder content, only the folder and the manga will be shown
-func browseGet(cfg *Config, db *FlatDB, tmpl *template.Template) func(http.ResponseWriter, *http.Request) {
+func browseGet(cfg *Config, db *FlatDB, httpSession *SessionStore, progress *ProgressStore, tmpl *template.Template) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "GET" {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 
+		user := requestUser(r)
+		if user == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// library as seen by the user
+		uv := newUserView(cfg, progress, user)
+
 		query := r.URL.Query()
 
 		// if multiple dir parameter is specified, pick by priority
@@ -92,12 +88,28 @@ func browseGet(cfg *Config, db *FlatDB, tmpl *template.Template) func(http.Respo
 			return
 		}
 
+		// remember device profile choice for the session
+		profile := sessionProfile(cfg, httpSession, w, r)
+		// profile for old browsers gets legacy page
+		t := tmpl
+		if r.URL.Path == "/browse.html" && profile.Template == TemplateLegacy {
+			t = tmplBrowseLegacy
+		}
+
 		dir := query.Get("dir")
+		// legacy page and paging links tick everywhere with their own parameter
+		if query.Get("everywhere") == "true" {
+			dir = string(specialPathEveryWhere)
+		}
 		keyword := strings.ToLower(query.Get("keyword"))
-		// TODO implement sortBy
+		// blank sort uses the default of each listing
 		sortBy := strings.ToLower(query.Get("sortby"))
-		if sortBy == "" {
-			sortBy = "name"
+		if !validSortBy(sortBy) {
+			sortBy = ""
+		}
+		order := strings.ToLower(query.Get("order"))
+		if order != sortAsc && order != sortDesc {
+			order = ""
 		}
 		spage := query.Get("page")
 		page, err := strconv.Atoi(spage)
@@ -143,25 +155,31 @@ func browseGet(cfg *Config, db *FlatDB, tmpl *template.Template) func(http.Respo
 			Page        int
 			Keyword     string
 			SortBy      string
+			Order       string
 			FileList    FileList
+			Pagination  Pagination
 			DirIsMore   bool
 			DirIsEmpty  bool
+			IsAdmin     bool
 		}{
-			AllowedDirs: cfg.AllowedDirs,
+			AllowedDirs: uv.dirs,
 			Paths:       paths,
 			Dir:         dir,
 			UpDir:       filepath.Dir(dir),
 			Page:        page,
 			Keyword:     keyword,
 			SortBy:      sortBy,
+			Order:       order,
 			FileList:    FileList{},
+			Pagination:  Pagination{Page: 1, Pages: 1},
+			IsAdmin:     user.IsAdmin(),
 		}
 
 		buf := bytes.Buffer{}
 
 		// no dir chosen
 		if dir == "" || dir == "." {
-			err = tmpl.Execute(&buf, data)
+			err = t.Execute(&buf, data)
 			if err != nil {
 				responseError(w, err)
 				return
@@ -172,10 +190,9 @@ func browseGet(cfg *Config, db *FlatDB, tmpl *template.Template) func(http.Respo
 			return
 		}
 
-		// data to client
-		fileList := FileList{}
-		var lstat int
-		// chopped lists by pagination
+		// data to client, first one is the dir info to save space
+		var header *FileInfoBasic
+		// whole sorted listing, chopped by pagination later
 		var lists FileList
 
 		if isSpecialPath(dir) {
@@ -184,28 +201,22 @@ func browseGet(cfg *Config, db *FlatDB, tmpl *template.Template) func(http.Respo
 				// tick everywhere checkbox
 				data.Everywhere = true
 
-				// add first one as the dir info to save space
-				fileList = append(fileList, &FileInfoBasic{
+				header = &FileInfoBasic{
 					IsDir: true,
 					Path:  "My Entire Library",
-				})
+				}
 
 				// build library list
-				lstat, lists, err = search(db, keyword, page)
-				if err != nil {
-					responseError(w, err)
-					return
-				}
+				lists = search(db, uv, keyword, sortBy, order)
 
 			case specialPathHistory,
 				specialPathHistoryFinished,
 				specialPathHistoryUnfinished:
 
-				// add first one as the dir info to save space
-				fileList = append(fileList, &FileInfoBasic{
+				header = &FileInfoBasic{
 					IsDir: true,
 					Path:  "My Read History",
-				})
+				}
 
 				readState := 0
 				switch specialPath(dir) {
@@ -216,83 +227,68 @@ func browseGet(cfg *Config, db *FlatDB, tmpl *template.Template) func(http.Respo
 				}
 
 				// build history list
-				lstat, lists, err = listByReadHistory(db, keyword, page, readState, sortBy)
-				if err != nil {
-					responseError(w, err)
-					return
-				}
+				lists = listByReadHistory(db, uv, keyword, readState, sortBy, order)
 
 			case specialPathFav:
 
-				// add first one as the dir info to save space
-				fileList = append(fileList, &FileInfoBasic{
+				header = &FileInfoBasic{
 					IsDir: true,
 					Path:  "My Read Fav",
-				})
+				}
 
 				// build fav list
-				readState := 0
-				lstat, lists, err = listByReadFav(db, keyword, page, readState, sortBy)
-				if err != nil {
-					responseError(w, err)
-					return
-				}
+				lists = listByReadFav(db, uv, keyword, sortBy, order)
 
 			case specialPathFavAll:
 
-				// add first one as the dir info to save space
-				fileList = append(fileList, &FileInfoBasic{
+				header = &FileInfoBasic{
 					IsDir: true,
 					Path:  "My Read Fav All",
-				})
+				}
 
 				// build fav list
-				readState := 0
-				lstat, lists, err = listByReadFavAll(db, keyword, page, readState, sortBy)
-				if err != nil {
-					responseError(w, err)
-					return
-				}
+				lists = listByReadFavAll(db, uv, keyword, sortBy, order)
 			}
 
 		} else {
 			// check if the dir is allowed to browse
-			exists := StringSliceContain(cfg.AllowedDirs, dir)
+			exists := uv.allowed(dir)
 			if !exists {
 				w.WriteHeader(http.StatusForbidden)
 				w.Write([]byte("not allowed to browse " + dir))
 				return
 			}
 
-			// add first one as the dir info to save space
-			fileList = append(fileList, &FileInfoBasic{
+			header = &FileInfoBasic{
 				IsDir: true,
 				Path:  dir,
-			})
+			}
 
 			// build dir list
-			lstat, lists, err = listDir(db, dir, keyword, page, sortBy)
+			lists, err = listDir(db, uv, dir, keyword, sortBy, order)
 			if err != nil {
 				responseError(w, err)
 				return
 			}
 		}
 
-		fileList = append(fileList, lists...)
+		lists, pagination := paginate(lists, page, profile.ItemsPerPage)
 
-		if lstat == 1 {
-			// no more files
-			data.DirIsEmpty = true
-		}
-		if lstat == 2 {
-			// more files
-			data.DirIsMore = true
+		// library listings come from db, only books shown are checked on disk so Total may count
+		// a few missing books until their page is viewed or the library is rescanned
+		if isSpecialPath(dir) {
+			lists = dropMissing(db, lists)
 		}
 
+		data.Page = pagination.Page
+		data.Pagination = pagination
+		data.DirIsMore = pagination.HasNext()
+		data.DirIsEmpty = !pagination.HasNext()
+
 		// fill file list data
-		data.FileList = fileList
+		data.FileList = append(FileList{header}, lists...)
 		// exec template
-		err = tmpl.Execute(&buf, data)
+		err = t.Execute(&buf, data)
 		if err != nil {
 			responseError(w, err)
 			return
@@ -303,19 +299,82 @@ func browseGet(cfg *Config, db *FlatDB, tmpl *template.Template) func(http.Respo
 	}
 }
 
-func listDir(db *FlatDB, dir, search string, page int, sortOrderBy string) (status int, fileList FileList, err error) {
-	/* status
-	-1 error
-	 0 no any particular state
-	 1 no more list to follow
-	 2 more list to follow
-	*/
-	status = -1
+// userView is the library as seen by the logged in user
+type userView struct {
+	user *User
+	up   *UserProgress // reading progress of the user
+	dirs []string      // dirs user allowed to browse
+}
 
+func newUserView(cfg *Config, progress *ProgressStore, user *User) *userView {
+	return &userView{
+		user: user,
+		up:   progress.For(user.Username),
+		dirs: cfg.UserAllowedDirs(user),
+	}
+}
+
+// allowed checks if user can access the path
+func (uv *userView) allowed(fpath string) bool {
+	return PathInDirs(uv.dirs, fpath)
+}
+
+// bookFileInfo creates listing entry for the book with reading progress of the user
+func bookFileInfo(book *Book, up *UserProgress) *FileInfoBasic {
+	fib := &FileInfoBasic{
+		IsBook:  true,
+		Name:    filepath.Base(book.Fullpath),
+		ModTime: time.Unix(int64(book.Mtime), 0),
+		Book:    *book,
+	}
+	up.Overlay(&fib.Book)
+
+	// make page 0 to 1 so wont crash on reading
+	if fib.Book.Page <= 0 {
+		fib.Book.Page = 1
+	}
+
+	return fib
+}
+
+// bookFileInfo creates listing entry for the book, nil if user is not allowed to see it.
+// book known to be missing is skipped too, until a scan or dir listing finds it again
+func (uv *userView) bookFileInfo(book *Book) *FileInfoBasic {
+	if book.Cond == BookCondMissing || !uv.allowed(book.Fullpath) {
+		return nil
+	}
+	return bookFileInfo(book, uv.up)
+}
+
+// dropMissing removes books that no longer exist on disk, and remember them as missing or found again
+func dropMissing(db *FlatDB, fileList FileList) FileList {
+	existList := FileList{}
+	for _, fib := range fileList {
+		if fib.IsBook {
+			isExist, err := IsFileExists(fib.Fullpath)
+			if err != nil {
+				continue
+			}
+			if !isExist {
+				db.MarkMissing(fib.ID)
+				continue
+			}
+			if fib.Cond == BookCondMissing {
+				db.MarkFound(fib.ID)
+			}
+		}
+		existList = append(existList, fib)
+	}
+
+	return existList
+}
+
+// listDir lists books and dirs in dir, sorted
+func listDir(db *FlatDB, uv *userView, dir, search string, sortBy, order string) (fileList FileList, err error) {
 	// listing dir
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
-		return status, nil, err
+		return nil, err
 	}
 
 	search = strings.ToLower(search)
@@ -369,8 +428,7 @@ OUTER:
 		}
 	}
 
-	// look up book details
-	// doing this way to reduce cpu/disk load, only load the relevant page
+	// look up book details, needed for sorting other than by name
 	for _, fib := range fileList {
 		if !fib.IsBook {
 			continue
@@ -382,12 +440,18 @@ OUTER:
 		// find book by path
 		book := db.GetBookByPath(fileFullPath)
 		if book != nil {
+			// file is in the dir, in case it was marked missing before
+			if book.Cond == BookCondMissing {
+				db.MarkFound(book.ID)
+			}
 			fib.Book = *book
+			fib.Book.Cond = BookCondExists
+			uv.up.Overlay(&fib.Book)
 		} else {
 			// book not found, add now
 			nbook, err := db.AddFile(fileFullPath)
 			if err != nil {
-				return status, nil, err
+				return nil, err
 			}
 			fib.Book = *nbook
 
@@ -401,371 +465,120 @@ OUTER:
 		}
 	}
 
-	// sort by natural order, if small enough, or lag happens
-
-
-	//log.Println("\n\n\n")
-	//log.Println("Dir listing before sort by x")
-	//log.Println(fileList)
-	//log.Println("\n\n\n")
-	//if len(fileList) <= SortMaxSize {
-		switch sortOrderBy {
-		case sortOrderByFileName:
-			fileList = sortByFileName(fileList)
-		case sortOrderByFileModTime:
-			fileList = sortByFileModTime(fileList)
-		case sortOrderByReadTime:
-			fileList = sortByReadTime(fileList)
-		case sortOrderByAuthor:
-			fileList = sortByAuthorTitle(fileList)
-		case sortOrderByFav:
-			fileList = sortByFav(fileList)
-		default:
-			fileList = sortByFileName(fileList)
-		}
-	//}
-
-	// pagination
-	head := (page - 1) * ItemsPerPage
-	if head > len(fileList) {
-		head = len(fileList)
-	}
-	tail := (page) * ItemsPerPage
-	if tail > len(fileList) {
-		tail = len(fileList)
-
-		// reached the end, no more files
-		status = 1
-	} else {
-		// indicate more files
-		status = 2
-	}
-	// chopped file list
-	fileList = fileList[head:tail]
-
-	// sort again, because earlier sort could be big and skipped
-	switch sortOrderBy {
-	case sortOrderByFileName:
-		fileList = sortByFileName(fileList)
-	case sortOrderByFileModTime:
-		fileList = sortByFileModTime(fileList)
-	case sortOrderByReadTime:
-		fileList = sortByReadTime(fileList)
-	case sortOrderByAuthor:
-		fileList = sortByAuthorTitle(fileList)
-	case sortOrderByFav:
-		fileList = sortByFav(fileList)
-	default:
-		fileList = sortByFileName(fileList)
+	if sortBy == "" {
+		sortBy = sortOrderByFileName
 	}
 
-	return status, fileList, nil
+	return sortFileList(fileList, sortBy, order), nil
 }
 
-func search(db *FlatDB, search string, page int) (status int, fileList FileList, err error) {
-	/* status
-	-1 error
-	 0 no any particular state
-	 1 no more list to follow
-	 2 more list to follow
-	*/
-	status = -1
-
+// search lists books in library matching the keywords
+func search(db *FlatDB, uv *userView, search string, sortBy, order string) (fileList FileList) {
 	books := db.Search(search)
 	for _, book := range books {
-		// skip if book not exist
-		isExist, err := IsFileExists(book.Fullpath)
-		if err != nil {
-			continue
-		}
-		if !isExist {
+		fib := uv.bookFileInfo(book)
+		if fib == nil {
 			continue
 		}
 
-		// create and store blank book entry
-		fib := &FileInfoBasic{
-			IsBook:  true,
-			Name:    filepath.Base(book.Fullpath),
-			ModTime: time.Unix(int64(book.Mtime), 0),
-			Book:    *book,
-		}
-
-		// make page 0 to 1 so wont crash on reading
-		if fib.Book.Page <= 0 {
-			fib.Book.Page = 1
-		}
-
 		fileList = append(fileList, fib)
 	}
 
-	// sort by natural order, if small enough, or lag happens
-	//if len(fileList) <= SortMaxSize {
-		fileList = sortByFileName(fileList)
-	//}
-
-	// pagination
-	head := (page - 1) * ItemsPerPage
-	if head > len(fileList) {
-		head = len(fileList)
-	}
-	tail := (page) * ItemsPerPage
-	if tail > len(fileList) {
-		tail = len(fileList)
-
-		// reached the end, no more files
-		status = 1
-	} else {
-		// indicate more files
-		status = 2
+	// keyword search is already ordered by match quality
+	if sortBy == "" {
+		sortBy = sortOrderByFileName
+		if len(StringSliceFlatten(strings.Split(search, " "))) > 0 {
+			sortBy = sortOrderByRelevance
+		}
 	}
-	// chopped file list
-	fileList = fileList[head:tail]
 
-	// sort again, because earlier sort could be big and skipped
-	fileList = sortByFileName(fileList)
-
-	return status, fileList, nil
+	return sortFileList(fileList, sortBy, order)
 }
 
-func listByReadHistory(db *FlatDB, search string, page int, readState int, sortOrderBy string) (status int, fileList FileList, err error) {
-	/* status
-	-1 error
-	 0 no any particular state
-	 1 no more list to follow
-	 2 more list to follow
-
-	   read state
+// listByReadHistory lists books that have been read
+func listByReadHistory(db *FlatDB, uv *userView, search string, readState int, sortBy, order string) (fileList FileList) {
+	/* read state
 	0  all
 	1  unfinished
 	2  finished
 	*/
-	status = -1
 
 	books := db.Search(search)
 	for _, book := range books {
+		fib := uv.bookFileInfo(book)
+		if fib == nil {
+			continue
+		}
+
 		// skip unread books
-		if book.Rtime == 0 {
+		if fib.Rtime == 0 {
 			continue
 		}
 		switch readState {
 		case 1:
 			// skip if require unfinish but finished
-			if book.Page >= book.Pages {
+			if fib.Page >= fib.Pages {
 				continue
 			}
 		case 2:
 			// skip if require finish but unfinished
-			if book.Page < book.Pages {
+			if fib.Page < fib.Pages {
 				continue
 			}
 		}
 
-		// create and store blank book entry
-		fib := &FileInfoBasic{
-			IsBook:  true,
-			Name:    filepath.Base(book.Fullpath),
-			ModTime: time.Unix(int64(book.Mtime), 0),
-			Book:    *book,
-		}
-
-		// make page 0 to 1 so wont crash on reading
-		if fib.Book.Page <= 0 {
-			fib.Book.Page = 1
-		}
-
 		fileList = append(fileList, fib)
 	}
 
-	// sort by read order
-
-	switch sortOrderBy {
-	case sortOrderByFileName:
-		fileList = sortByFileName(fileList)
-	case sortOrderByFileModTime:
-		fileList = sortByFileModTime(fileList)
-	case sortOrderByReadTime:
-		fileList = sortByReadTime(fileList)
-	case sortOrderByAuthor:
-		fileList = sortByAuthorTitle(fileList)
-	case sortOrderByFav:
-		fileList = sortByFav(fileList)
-	default:
-		fileList = sortByReadTime(fileList)
-	}
-
-
-	// pagination
-	head := (page - 1) * ItemsPerPage
-	if head > len(fileList) {
-		head = len(fileList)
-	}
-	tail := (page) * ItemsPerPage
-	if tail > len(fileList) {
-		tail = len(fileList)
-
-		// reached the end, no more files
-		status = 1
-	} else {
-		// indicate more files
-		status = 2
-	}
-	// chopped file list
-	fileList = fileList[head:tail]
-
-	// sort again, because earlier sort could be big and skipped
-	switch sortOrderBy {
-	case sortOrderByFileName:
-		fileList = sortByFileName(fileList)
-	case sortOrderByFileModTime:
-		fileList = sortByFileModTime(fileList)
-	case sortOrderByReadTime:
-		fileList = sortByReadTime(fileList)
-	case sortOrderByAuthor:
-		fileList = sortByAuthorTitle(fileList)
-	case sortOrderByFav:
-		fileList = sortByFav(fileList)
-	default:
-		fileList = sortByReadTime(fileList)
+	// sort by most recent read by default
+	if sortBy == "" {
+		sortBy = sortOrderByReadTime
 	}
 
-	return status, fileList, nil
+	return sortFileList(fileList, sortBy, order)
 }
 
-func listByReadFav(db *FlatDB, search string, page int, readState int, sortOrderBy string) (status int, fileList FileList, err error) {
-	/* status
-	-1 error
-	 0 no any particular state
-	 1 no more list to follow
-	 2 more list to follow
-
-	   fav state
-	0  not fav
-	1  fav
-	*/
-	status = -1
-        var nonfavbooks []*Book
-
+// listByReadFav lists favourited books
+func listByReadFav(db *FlatDB, uv *userView, search string, sortBy, order string) (fileList FileList) {
 	books := db.Search(search)
 	for _, book := range books {
-		// skip non favorited books
-		if book.Fav == 0 {
-			nonfavbooks = append(nonfavbooks, book)
+		fib := uv.bookFileInfo(book)
+		if fib == nil {
 			continue
-			
-		}
-		
-		// create and store blank book entry
-		fib := &FileInfoBasic{
-			IsBook:  true,
-			Name:    filepath.Base(book.Fullpath),
-			ModTime: time.Unix(int64(book.Mtime), 0),
-			Book:    *book,
 		}
 
-		// make page 0 to 1 so wont crash on reading
-		if fib.Book.Page <= 0 {
-			fib.Book.Page = 1
+		// skip non favorited books
+		if fib.Fav == 0 {
+			continue
 		}
-		//fmt.Println("Book: ", book.Fullpath, " Fav: ",  book.Fav)
+
 		fileList = append(fileList, fib)
 	}
 
-	// pagination
-	head := (page - 1) * ItemsPerPage
-	if head > len(fileList) {
-		head = len(fileList)
-	}
-	tail := (page) * ItemsPerPage
-	if tail > len(fileList) {
-		tail = len(fileList)
-
-		// reached the end, no more files
-		status = 1
-	} else {
-		// indicate more files
-		status = 2
+	if sortBy == "" {
+		sortBy = sortOrderByReadTime
 	}
-	// chopped file list
-	fileList = fileList[head:tail]
 
-	return status, fileList, nil
+	return sortFileList(fileList, sortBy, order)
 }
 
-func listByReadFavAll(db *FlatDB, search string, page int, readState int, sortOrderBy string) (status int, fileList FileList, err error) {
-	/* status
-	-1 error
-	 0 no any particular state
-	 1 no more list to follow
-	 2 more list to follow
-
-	   fav state
-	0  not fav
-	1  fav
-	*/
-	status = -1
-        var nonfavbooks []*Book
-
+// listByReadFavAll lists all books, favourited books first
+func listByReadFavAll(db *FlatDB, uv *userView, search string, sortBy, order string) (fileList FileList) {
 	books := db.Search(search)
 	for _, book := range books {
-		// skip non favorited books
-		if book.Fav == 0 {
-			nonfavbooks = append(nonfavbooks, book)
+		fib := uv.bookFileInfo(book)
+		if fib == nil {
 			continue
-			
-		}
-		
-		// create and store blank book entry
-		fib := &FileInfoBasic{
-			IsBook:  true,
-			Name:    filepath.Base(book.Fullpath),
-			ModTime: time.Unix(int64(book.Mtime), 0),
-			Book:    *book,
 		}
 
-		// make page 0 to 1 so wont crash on reading
-		if fib.Book.Page <= 0 {
-			fib.Book.Page = 1
-		}
-		fmt.Println("Book: ", book.Fullpath, " Fav: ",  book.Fav)
 		fileList = append(fileList, fib)
 	}
 
-	for _, book2 := range nonfavbooks {
-		
-		// create and store blank book entry
-		fib2 := &FileInfoBasic{
-			IsBook:  true,
-			Name:    filepath.Base(book2.Fullpath),
-			ModTime: time.Unix(int64(book2.Mtime), 0),
-			Book:    *book2,
-		}
-
-		// make page 0 to 1 so wont crash on reading
-		if fib2.Book.Page <= 0 {
-			fib2.Book.Page = 1
-		}
-                fmt.Println("Book2: ", book2.Fullpath, " Fav: ",  book2.Fav)
-		fileList = append(fileList, fib2)
+	if sortBy == "" {
+		sortBy = sortOrderByReadTime
 	}
+	fileList = sortFileList(fileList, sortBy, order)
 
-	// pagination
-	head := (page - 1) * ItemsPerPage
-	if head > len(fileList) {
-		head = len(fileList)
-	}
-	tail := (page) * ItemsPerPage
-	if tail > len(fileList) {
-		tail = len(fileList)
-
-		// reached the end, no more files
-		status = 1
-	} else {
-		// indicate more files
-		status = 2
-	}
-	// chopped file list
-	fileList = fileList[head:tail]
-
-
-	return status, fileList, nil
-}
\ No newline at end of file
+	// favourited books first, each group keeps the chosen order
+	return favFirst(fileList)
+}
@@ -2,16 +2,16 @@ package main
 
 import (
 	"bytes"
-	"crypto/subtle"
 	"encoding/base64"
 	"errors"
 	"html/template"
 	"log"
 	"net/http"
+	"strconv"
 )
 
 // loginPOST is POST login
-func loginPOST(httpSession *SessionStore, cfg *Config) func(http.ResponseWriter, *http.Request) {
+func loginPOST(httpSession *SessionStore, cfg *Config, guard *LoginGuard) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "POST" {
 			w.WriteHeader(http.StatusNotFound)
@@ -38,13 +38,41 @@ func loginPOST(httpSession *SessionStore, cfg *Config) func(http.ResponseWriter,
 			RawQuery: r.Form.Get("rawquery"),
 		}
 
-		// more secure compare
-		strCrypt := SHA256Iter(t.Password, cfg.Salt, ConfigHashIterations)
-		if subtle.ConstantTimeCompare([]byte(strCrypt), []byte(cfg.Crypt)) == 1 {
-			// create new session
-			httpSession.Set(w, r, LoggedIn, true)
+		// too many failures, dont even check the password
+		ip := clientIP(r)
+		wait := guard.Check(ip, t.Username)
+		if wait > 0 {
+			log.Println("login blocked", t.Username, "from", ip)
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte("too many failed login, try again later"))
+			return
+		}
+
+		user := cfg.User(t.Username)
+		if user == nil {
+			// still compute hash so response time dont reveal if username exists
+			user = &User{Algorithm: HashAlgorithm, Iterations: cfg.Iterations}
+		}
 
-			log.Println("logged in")
+		if user.CheckPassword(t.Password) {
+			guard.Success(ip, t.Username)
+
+			// upgrade old or weaker hash while the password is known
+			if user.NeedsRehash(cfg.Iterations) {
+				err := cfg.RehashPassword(user.Username, t.Password)
+				if err != nil {
+					log.Println("failed to rehash password of", user.Username, err)
+				} else {
+					log.Println("rehashed password of", user.Username)
+				}
+			}
+
+			// new session id on login, prevent session fixation
+			httpSession.Rotate(w, r)
+			httpSession.Set(w, r, SessionUsername, user.Username)
+
+			log.Println("logged in", user.Username)
 
 			rquery := ""
 			origQuery, uerr := base64.URLEncoding.DecodeString(t.RawQuery)
@@ -62,13 +90,15 @@ func loginPOST(httpSession *SessionStore, cfg *Config) func(http.ResponseWriter,
 			return
 		}
 
+		guard.Failure(ip, t.Username)
+
 		w.WriteHeader(http.StatusUnauthorized)
 		w.Write([]byte("wrong username or password"))
 	}
 }
 
 // loginGet login Get page
-func loginGet(cfg *Config, db *FlatDB, tmpl *template.Template) func(http.ResponseWriter, *http.Request) {
+func loginGet(cfg *Config, db *FlatDB, basicAuth *BasicAuth, csrf *CSRF, tmpl *template.Template) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "GET" {
 			w.WriteHeader(http.StatusNotFound)
@@ -79,13 +109,35 @@ func loginGet(cfg *Config, db *FlatDB, tmpl *template.Template) func(http.Respon
 		referer := query.Get("referer")
 		rawQuery := query.Get("rawquery")
 
+		// login with browser prompt, for clients without cookie
+		if basicAuth != nil && query.Get("basic") == "1" {
+			if requestUser(r) == nil {
+				basicAuth.Challenge(w)
+				return
+			}
+
+			if len(referer) == 0 {
+				referer = "/browse.html"
+			}
+			origQuery, err := base64.URLEncoding.DecodeString(rawQuery)
+			if err == nil && len(origQuery) > 0 {
+				referer += "?" + string(origQuery)
+			}
+			http.Redirect(w, r, referer, http.StatusFound)
+			return
+		}
+
 		// login template
 		data := struct {
-			Referer  string
-			RawQuery string
+			Referer   string
+			RawQuery  string
+			BasicAuth bool
+			CSRF      string
 		}{
-			Referer:  referer,
-			RawQuery: rawQuery,
+			Referer:   referer,
+			RawQuery:  rawQuery,
+			BasicAuth: basicAuth != nil,
+			CSRF:      csrf.Token(w, r),
 		}
 
 		// exec template
@@ -1,16 +1,18 @@
 package main
 
 import (
-	"archive/zip"
 	"bytes"
 	"errors"
 	"fmt"
 	"html/template"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Blank use to blank sensitive or not needed data
@@ -23,52 +25,74 @@ type BooksResponse []*Book
 type MapBooksResponse map[string]*Book
 
 // readGet http Get read page
-func readGet(cfg *Config, db *FlatDB, tmpl *template.Template) func(http.ResponseWriter, *http.Request) {
+func readGet(cfg *Config, db *FlatDB, progress *ProgressStore, httpSession *SessionStore, csrf *CSRF, tmpl *template.Template) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "GET" {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 
+		user := requestUser(r)
+		if user == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		up := progress.For(user.Username)
+
 		query := r.URL.Query()
 
 		bookID := query.Get("book")
 		spage := query.Get("page")
-		fav := query.Get("fav")
 		page, err := strconv.Atoi(spage)
 		if err != nil {
 			page = 1
 		}
 
-		book := db.GetBookByID(bookID)
-		if book == nil {
+		dbBook := db.GetBookByID(bookID)
+		if dbBook == nil {
 			responseBadRequest(w, errors.New("book not found"))
 			return
 		}
-		if fav == "1" {
-			book.Fav = 1
-		} else if fav == "0" {
-			book.Fav = 0
+		if !PathInDirs(cfg.UserAllowedDirs(user), dbBook.Fullpath) {
+			w.WriteHeader(http.StatusForbidden)
+			return
 		}
+		// copy, so reading state of the user dont change shared book
+		book := &Book{}
+		*book = *dbBook
+		up.Overlay(book)
+
 		if page < 1 || page > int(book.Pages) {
 			responseBadRequest(w, errors.New("invalid page number"))
 			return
 		}
-		// set page temporary so reflect the html
+		// set page so reflect the html
 		book.Page = int64(page)
-		// set fav temporary so reflect the html
-		
+
+		// page image urls carry the version, so browser can keep them
+		fstat, err := os.Stat(book.Fullpath)
+		if err != nil {
+			responseError(w, err)
+			return
+		}
+		opt, render := pageOptions(cfg, httpSession, w, r)
 
 		// read template
 		data := struct {
 			Dir     string
 			DirPage int
 			Book    *Book
+			Version string
+			Crop    bool
+			CSRF    string
 			// Resolution?
 		}{
 			Dir:     filepath.Dir(book.Fullpath),
 			DirPage: 1,
 			Book:    book,
+			Version: pageVersion(fstat.ModTime(), opt, render),
+			Crop:    opt.Crop,
+			CSRF:    csrf.Token(w, r),
 		}
 
 		// exec template
@@ -82,16 +106,131 @@ func readGet(cfg *Config, db *FlatDB, tmpl *template.Template) func(http.Respons
 		w.Header().Set("Content-Type", "text/html")
 		w.Write([]byte(buf.String()))
 
-		// set/unset book favourite permanently
-		if fav == "1" {
-			db.UpdateFav(bookID, true)
-		} else if fav == "0" {
-			db.UpdateFav(bookID, false)
+		// guest can read but progress is not kept
+		if !requestCanWrite(r) {
+			return
 		}
 
 		// set page read permanently
-		db.UpdatePage(bookID, page)
+		err = up.UpdatePage(bookID, page)
+		if err != nil {
+			fmt.Printf("error: failed to update page %+v\n", err)
+		}
+
+	}
+}
+
+// favPOST http POST set or unset book favourite, then back to the read page
+func favPOST(cfg *Config, db *FlatDB, progress *ProgressStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		err := r.ParseForm()
+		if err != nil {
+			responseBadRequest(w, errors.New("cannot parse form data"))
+			return
+		}
+
+		bookID := r.Form.Get("book")
+		page, err := strconv.Atoi(r.Form.Get("page"))
+		if err != nil {
+			page = 1
+		}
+
+		book := db.GetBookByID(bookID)
+		if book == nil {
+			responseBadRequest(w, errors.New("book not found"))
+			return
+		}
+		user := requestUser(r)
+		if !PathInDirs(cfg.UserAllowedDirs(user), book.Fullpath) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		// set/unset book favourite permanently
+		err = progress.For(user.Username).UpdateFav(bookID, r.Form.Get("fav") == "1")
+		if err != nil {
+			responseError(w, err)
+			return
+		}
+
+		http.Redirect(w, r, "/read.html?book="+url.QueryEscape(bookID)+"&page="+strconv.Itoa(page), http.StatusSeeOther)
+	}
+}
+
+// rankingPOST http POST set book ranking of the user, then back to the read page
+func rankingPOST(cfg *Config, db *FlatDB, progress *ProgressStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		err := r.ParseForm()
+		if err != nil {
+			responseBadRequest(w, errors.New("cannot parse form data"))
+			return
+		}
+
+		bookID := r.Form.Get("book")
+		page, err := strconv.Atoi(r.Form.Get("page"))
+		if err != nil {
+			page = 1
+		}
+		ranking, err := strconv.Atoi(r.Form.Get("ranking"))
+		if err != nil {
+			responseBadRequest(w, errors.New("invalid ranking"))
+			return
+		}
 
+		book := db.GetBookByID(bookID)
+		if book == nil {
+			responseBadRequest(w, errors.New("book not found"))
+			return
+		}
+		user := requestUser(r)
+		if !PathInDirs(cfg.UserAllowedDirs(user), book.Fullpath) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		err = progress.For(user.Username).UpdateRanking(bookID, ranking)
+		if err != nil {
+			responseBadRequest(w, err)
+			return
+		}
+
+		http.Redirect(w, r, "/read.html?book="+url.QueryEscape(bookID)+"&page="+strconv.Itoa(page), http.StatusSeeOther)
+	}
+}
+
+// cropPOST http POST turn margin crop of reader pages on or off for the session, then back to the read page
+func cropPOST(httpSession *SessionStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		err := r.ParseForm()
+		if err != nil {
+			responseBadRequest(w, errors.New("cannot parse form data"))
+			return
+		}
+
+		bookID := r.Form.Get("book")
+		page, err := strconv.Atoi(r.Form.Get("page"))
+		if err != nil {
+			page = 1
+		}
+
+		httpSession.Set(w, r, SessionCrop, r.Form.Get("crop") == "1")
+
+		http.Redirect(w, r, "/read.html?book="+url.QueryEscape(bookID)+"&page="+strconv.Itoa(page), http.StatusSeeOther)
 	}
 }
 
@@ -115,6 +254,21 @@ func renderThumbnail(db *FlatDB, cfg *Config) func(http.ResponseWriter, *http.Re
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
+		user := requestUser(r)
+		if user == nil || !PathInDirs(cfg.UserAllowedDirs(user), book.Fullpath) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		// cover only changes with the book, browse page links ?v={mtime}
+		version := strconv.FormatInt(book.Mtime, 10)
+		cacheControl := CacheRevalidate
+		if r.URL.Query().Get("v") == version {
+			cacheControl = CacheImmutable
+		}
+		if notModified(w, r, `"thumb-`+bookID+"-"+version+`"`, time.Unix(book.Mtime, 0), cacheControl) {
+			return
+		}
 
 		// locally stored thumbnail file
 		outFile := filepath.Join(cfg.PathCache, bookID+".jpg")
@@ -159,14 +313,14 @@ func renderThumbnail(db *FlatDB, cfg *Config) func(http.ResponseWriter, *http.Re
 }
 
 // readPage returns image of the page from the book with option to update bookmark
-func readPage(db *FlatDB, updateBookmark bool) func(http.ResponseWriter, *http.Request) {
+func readPage(cfg *Config, db *FlatDB, progress *ProgressStore, httpSession *SessionStore, pages *PageReader, updateBookmark bool) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "GET" {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 
-		bookID, page, err := parseURIBookIDandPage(r.RequestURI, "/api/read/")
+		bookID, page, err := parseURIBookIDandPage(r.URL.Path, "/api/read/")
 		if err != nil {
 			responseBadRequest(w, err)
 			return
@@ -182,88 +336,145 @@ func readPage(db *FlatDB, updateBookmark bool) func(http.ResponseWriter, *http.R
 			return
 		}
 
-		fp := book.Fullpath
+		user := requestUser(r)
+		if user == nil || !PathInDirs(cfg.UserAllowedDirs(user), book.Fullpath) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
 
 		if page > int(book.Pages) {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 
-		imgDat, err := cbzPage(fp, page)
-		if err != nil {
-			responseError(w, err)
-			return
-		}
+		opt, render := pageOptions(cfg, httpSession, w, r)
 
-		if updateBookmark {
-			_, err = db.UpdatePage(bookID, page)
+		// bookmark first, it is kept even when browser has the image already
+		if updateBookmark && requestCanWrite(r) {
+			err = progress.For(user.Username).UpdatePage(bookID, page)
 			if err != nil {
 				fmt.Printf("error: failed to update page %+v\n", err)
 			}
 		}
 
-		ctype := http.DetectContentType(imgDat)
-		w.Header().Add("Content-Type", ctype)
-		w.Header().Add("Content-Length", strconv.Itoa(len(imgDat)))
-		w.Write(imgDat)
-	}
-}
+		// same book file and rendering gives same image
+		fstat, err := os.Stat(book.Fullpath)
+		if err != nil {
+			responseError(w, err)
+			return
+		}
+		version := pageVersion(fstat.ModTime(), opt, render)
+		etag := `"` + bookID + "-" + strconv.Itoa(page) + "-" + version + `"`
+		cacheControl := CacheRevalidate
+		if r.URL.Query().Get("v") == version {
+			cacheControl = CacheImmutable
+		}
+		if notModified(w, r, etag, fstat.ModTime(), cacheControl) {
+			return
+		}
 
-// cbzPage retrives a page from cbz
-func cbzPage(bookPath string, page int) ([]byte, error) {
-	// page starts at 1 (0 is null)
-	// file counter starts at 0. it is still a page, just internal
+		// next tap is likely the next page
+		defer pages.Prefetch(book, page, opt, render)
 
-	zr, err := zip.OpenReader(bookPath)
-	if err != nil {
-		return nil, err
-	}
-	defer zr.Close()
+		// original image not in memory is streamed from the archive, so memory used stays small
+		imgDat, ok := pages.Cached(book, fstat.ModTime().Unix(), page, opt, render)
+		if !ok && !render {
+			content, err := archives.OpenContent(book.Fullpath, page)
+			if err != nil {
+				responseError(w, err)
+				return
+			}
+			defer content.Close()
 
-	files := []string{}
-	for _, f := range zr.File {
-		if !RegexSupportedImageExt.MatchString(f.Name) {
-			continue
+			// range requests are handled too
+			http.ServeContent(w, r, content.Entry.Name, fstat.ModTime(), content)
+			return
+		}
+		if !ok {
+			imgDat, err = pages.Page(book, page, opt, render)
+			if err != nil {
+				responseError(w, err)
+				return
+			}
 		}
 
-		files = append(files, f.Name)
+		w.Header().Set("Content-Type", http.DetectContentType(imgDat))
+		http.ServeContent(w, r, "", fstat.ModTime(), bytes.NewReader(imgDat))
 	}
+}
 
-	// do natural sort
-	files = sortNatural(files, RegexSupportedImageExt)
-
-	// image data to serve
-	var imgDat []byte
-
-	if page > len(files) {
-		return nil, errors.New("page beyond file #")
+// pageOptions gives how reader page is rendered for the device, render false to send original image.
+// ?w= and ?h= take priority over the session device profile
+func pageOptions(cfg *Config, httpSession *SessionStore, w http.ResponseWriter, r *http.Request) (PageOptions, bool) {
+	opt := sessionProfile(cfg, httpSession, w, r).PageOptions(cfg)
+	if cfg.ImageResize {
+		query := r.URL.Query()
+		if v, err := strconv.Atoi(query.Get("w")); err == nil && v > 0 {
+			opt.MaxWidth = v
+		}
+		if v, err := strconv.Atoi(query.Get("h")); err == nil && v > 0 {
+			opt.MaxHeight = v
+		}
 	}
-	// image file to get in zip
-	getImgFileName := files[page-1]
+	// margin crop is chosen in the reader
+	opt.Crop, _ = httpSession.Get(w, r, SessionCrop).(bool)
+	render := opt.MaxWidth > 0 || opt.MaxHeight > 0 || opt.Format != "" || opt.Grayscale || opt.Crop || opt.EInk != nil
 
-	if getImgFileName == "" {
-		return nil, errors.New("failed to find image")
-	}
+	return opt, render
+}
 
-	for _, f := range zr.File {
-		if f.Name != getImgFileName {
-			continue
+// bookmarkPOST http POST keep the page read, for reader showing page image the browser had cached
+func bookmarkPOST(cfg *Config, db *FlatDB, progress *ProgressStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusNotFound)
+			return
 		}
 
-		rc, err := f.Open()
+		err := r.ParseForm()
 		if err != nil {
-			return nil, err
+			responseBadRequest(w, errors.New("cannot parse form data"))
+			return
 		}
-		defer rc.Close()
 
-		imgDat, err = ioutil.ReadAll(rc)
+		bookID := r.Form.Get("book")
+		page, err := strconv.Atoi(r.Form.Get("page"))
 		if err != nil {
-			return nil, err
+			responseBadRequest(w, errors.New("invalid page, must be a number"))
+			return
 		}
-		break
+
+		book := db.GetBookByID(bookID)
+		if book == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		user := requestUser(r)
+		if !PathInDirs(cfg.UserAllowedDirs(user), book.Fullpath) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if page < 1 || page > int(book.Pages) {
+			responseBadRequest(w, errors.New("invalid page number"))
+			return
+		}
+
+		// guest can read but progress is not kept
+		if requestCanWrite(r) {
+			err = progress.For(user.Username).UpdatePage(bookID, page)
+			if err != nil {
+				responseError(w, err)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
 	}
+}
 
-	return imgDat, nil
+// cbzPage retrives a page from cbz, page starts at 1
+func cbzPage(bookPath string, page int) ([]byte, error) {
+	return archives.ReadPage(bookPath, page)
 }
 
 // parseURIBookIDandPage parse url and return book id and page. it also do http error if failed
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"net/http"
+)
+
+// settingsGet http GET settings page, choose device profile for the session
+func settingsGet(cfg *Config, httpSession *SessionStore, csrf *CSRF, tmpl *template.Template) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// blank when profile is picked by user agent
+		chosen, _ := httpSession.Get(w, r, SessionProfile).(string)
+		suggested := ""
+		if p := cfg.SuggestProfile(r.UserAgent()); p != nil {
+			suggested = p.Name
+		}
+
+		// settings template
+		data := struct {
+			Profiles  []*DeviceProfile
+			Current   *DeviceProfile
+			Chosen    string
+			Suggested string
+			UserAgent string
+			Redirect  string
+			CSRF      string
+		}{
+			Profiles:  cfg.Profiles,
+			Current:   sessionProfile(cfg, httpSession, w, r),
+			Chosen:    chosen,
+			Suggested: suggested,
+			UserAgent: r.UserAgent(),
+			Redirect:  r.URL.Query().Get("redirect"),
+			CSRF:      csrf.Token(w, r),
+		}
+
+		// exec template
+		buf := bytes.Buffer{}
+		err := tmpl.Execute(&buf, data)
+		if err != nil {
+			responseError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(buf.String()))
+	}
+}
+
+// settingsPOST http POST keep chosen device profile in the session, blank profile goes back to user agent suggestion
+func settingsPOST(cfg *Config, httpSession *SessionStore) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		err := r.ParseForm()
+		if err != nil {
+			responseBadRequest(w, errors.New("cannot parse form data"))
+			return
+		}
+
+		name := r.PostFormValue("profile")
+		if name != "" && cfg.Profile(name).Name != name {
+			responseBadRequest(w, errors.New("unknown profile "+name))
+			return
+		}
+		httpSession.Set(w, r, SessionProfile, name)
+
+		// back to the page settings was opened from, local paths only
+		redirect := r.PostFormValue("redirect")
+		if redirect == "" || redirect[0] != '/' || (len(redirect) > 1 && (redirect[1] == '/' || redirect[1] == '\\')) {
+			redirect = "/settings.html"
+		}
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+	}
+}
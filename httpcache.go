@@ -0,0 +1,58 @@
+package main
+
+// http caching of page images and thumbnails, validators and conditional GET
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cache control of images
+const (
+	CacheImmutable  = "private, max-age=31536000, immutable" // url carries the version, content never changes
+	CacheRevalidate = "private, no-cache"                    // may be kept, but check with server before use
+)
+
+// pageVersion is version of page image, changes when the book file or the rendering changes.
+// it goes in page url as ?v= so the url of a version can be cached for good
+func pageVersion(mtime time.Time, opt PageOptions, render bool) string {
+	return strconv.FormatInt(mtime.Unix(), 10) + "-" + pageKey(opt, render)
+}
+
+// etagMatch checks If-None-Match header against the etag, weak comparison as for GET
+func etagMatch(header, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets validators and cache control, then answers 304 if the client copy is current.
+// true means response is done
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time, cacheControl string) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", cacheControl)
+	if !modTime.IsZero() {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+
+	// If-None-Match takes priority, If-Modified-Since only when it is not sent
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatch(inm, etag) {
+			return false
+		}
+	} else {
+		ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err != nil || modTime.IsZero() || modTime.Truncate(time.Second).After(ims) {
+			return false
+		}
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
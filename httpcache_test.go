@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestETagMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{`"v1"`, true},
+		{`W/"v1"`, true},
+		{`"v0", "v1"`, true},
+		{`"v0",W/"v1"`, true},
+		{`*`, true},
+		{`"v2"`, false},
+		{`v1`, false},
+		{``, false},
+	}
+
+	for _, tt := range tests {
+		got := etagMatch(tt.header, `"v1"`)
+		if got != tt.want {
+			t.Errorf("etagMatch(%s) = %t, want %t", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	modTime := time.Date(2020, 5, 1, 12, 0, 0, 500, time.UTC)
+	at := func(d time.Duration) string { return modTime.Add(d).Format(http.TimeFormat) }
+
+	tests := []struct {
+		name    string
+		inm     string // If-None-Match
+		ims     string // If-Modified-Since
+		modTime time.Time
+		want    bool
+	}{
+		{"no validators", "", "", modTime, false},
+		{"etag match", `"v1"`, "", modTime, true},
+		{"weak etag match", `W/"v1"`, "", modTime, true},
+		{"etag differs", `"v0"`, "", modTime, false},
+		{"etag differs, date ignored", `"v0"`, at(time.Hour), modTime, false},
+		{"etag match, date ignored", `"v1"`, at(-time.Hour), modTime, true},
+		{"same second", "", at(0), modTime, true},
+		{"later date", "", at(time.Hour), modTime, true},
+		{"modified since", "", at(-time.Second), modTime, false},
+		{"bad date", "", "yesterday", modTime, false},
+		{"no modification time", "", at(0), time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.inm != "" {
+			r.Header.Set("If-None-Match", tt.inm)
+		}
+		if tt.ims != "" {
+			r.Header.Set("If-Modified-Since", tt.ims)
+		}
+		w := httptest.NewRecorder()
+
+		got := notModified(w, r, `"v1"`, tt.modTime, CacheRevalidate)
+		if got != tt.want {
+			t.Errorf("%s: notModified() = %t, want %t", tt.name, got, tt.want)
+		}
+		if got && w.Code != http.StatusNotModified {
+			t.Errorf("%s: status %d, want 304", tt.name, w.Code)
+		}
+		if !got && w.Code != http.StatusOK {
+			// nothing written yet, handler goes on with the body
+			t.Errorf("%s: status %d written", tt.name, w.Code)
+		}
+		if w.Header().Get("ETag") != `"v1"` || w.Header().Get("Cache-Control") != CacheRevalidate {
+			t.Errorf("%s: validators not set, header %v", tt.name, w.Header())
+		}
+		if lm := w.Header().Get("Last-Modified"); (lm != "") != !tt.modTime.IsZero() {
+			t.Errorf("%s: Last-Modified %q", tt.name, lm)
+		}
+	}
+}
@@ -3,13 +3,20 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"image"
-	_ "image/gif"
+	"image/gif"
 	"image/jpeg"
-	_ "image/png"
+	"image/png"
 	"io"
 )
 
+// jpeg qualities
+const (
+	DefaultImageQuality = 75 // resized reader page, when config dont specify
+	ThumbQuality        = 50 // book cover thumbnail
+)
+
 // ImageThumb create thumbnail image
 func ImageThumb(reader io.Reader) ([]byte, error) {
 	return ImageScale(reader, 320, 320)
@@ -56,47 +63,132 @@ func ImageScale(reader io.Reader, miW, miH int) ([]byte, error) {
 		}
 	}
 
-	return ImageResize(reader3, thmW, thmH)
+	return ImageResize(reader3, thmW, thmH, ThumbQuality, FilterArea)
 }
 
-// ImageResize resize image to specific width, height
-func ImageResize(reader io.Reader, owidth int, oheight int) ([]byte, error) {
-	m, _, err := image.Decode(reader)
+// ImageFit gives dimension that fits within maxW x maxH while maintaining ratio, never enlarges.
+// 0 max means no limit on that side
+func ImageFit(w, h, maxW, maxH int) (int, int) {
+	ratio := 1.0
+	if maxW > 0 && w > maxW {
+		ratio = float64(maxW) / float64(w)
+	}
+	if maxH > 0 && h > maxH && float64(maxH)/float64(h) < ratio {
+		ratio = float64(maxH) / float64(h)
+	}
+	if ratio >= 1 {
+		return w, h
+	}
+
+	fw := int(MathRound(float64(w) * ratio))
+	fh := int(MathRound(float64(h) * ratio))
+	if fw < 1 {
+		fw = 1
+	}
+	if fh < 1 {
+		fh = 1
+	}
+	return fw, fh
+}
+
+// PageOptions how a book page is rendered for the device
+type PageOptions struct {
+	MaxWidth  int          // downscale to fit, 0 for no limit
+	MaxHeight int          // downscale to fit, 0 for no limit
+	Filter    string       // resampling filter
+	Quality   int          // jpeg quality
+	Grayscale bool         // convert to grayscale
+	Crop      bool         // trim uniform margins before resizing
+	EInk      *EInkOptions // convert for e-ink display, nil to keep colour
+	Format    string       // output format, jpeg, png or gif. blank for jpeg
+}
+
+// image output formats
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+)
+
+// MaxPagePixels largest page that is rendered, decoded image takes 4 bytes a pixel or more
+var MaxPagePixels = 16 << 20
+
+// RenderPage shrinks page image to fit and converts for the device when asked,
+// gives back the original data when nothing needs to change
+func RenderPage(data []byte, opt PageOptions) ([]byte, error) {
+	// check size before decoding the whole image
+	imgCfg, format, err := image.DecodeConfig(bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
+	w, h := ImageFit(imgCfg.Width, imgCfg.Height, opt.MaxWidth, opt.MaxHeight)
+	sameFormat := opt.Format == "" || opt.Format == format
+	if w == imgCfg.Width && h == imgCfg.Height && sameFormat && !opt.Grayscale && !opt.Crop && opt.EInk == nil {
+		return data, nil
+	}
+	// whole image is decoded in memory, int64 so huge sizes cannot overflow on 32-bit arm
+	if int64(imgCfg.Width)*int64(imgCfg.Height) > int64(MaxPagePixels) {
+		return nil, errors.New("page too big to render")
+	}
 
-	bounds := m.Bounds()
+	m, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if opt.Crop {
+		// fit what is left after trimming
+		m = CropMargins(m)
+		w, h = ImageFit(m.Bounds().Dx(), m.Bounds().Dy(), opt.MaxWidth, opt.MaxHeight)
+	}
+	m = Resample(m, w, h, opt.Filter)
+
+	switch {
+	case opt.EInk != nil:
+		m = EInkImage(m, *opt.EInk)
+	case opt.Grayscale:
+		m = grayImage(m)
+	}
 
-	// ratio
-	var rx, ry float32
-	rx = float32(owidth) / float32(bounds.Max.X)
-	ry = float32(oheight) / float32(bounds.Max.Y)
-
-	// new blank canvas
-	newImg := image.NewRGBA(
-		image.Rectangle{
-			image.Point{0, 0},
-			image.Point{owidth, oheight},
-		},
-	)
-
-	// fill canvas using skip pixel (nearest neighbour)
-	for x := 0; x < owidth; x++ {
-		for y := 0; y < oheight; y++ {
-			// imported image cord
-			ix := int(float32(x) / rx)
-			iy := int(float32(y) / ry)
-
-			rgba := m.At(ix, iy)
-
-			newImg.Set(x, y, rgba)
+	return ImageEncode(m, opt.Format, opt.Quality)
+}
+
+// ImageEncode encodes image in format, quality is for jpeg only
+func ImageEncode(m image.Image, format string, quality int) ([]byte, error) {
+	var b bytes.Buffer
+	var err error
+
+	switch format {
+	case FormatPNG:
+		enc := png.Encoder{CompressionLevel: png.BestCompression}
+		err = enc.Encode(&b, m)
+	case FormatGIF:
+		// colour image is quantized to web palette by the encoder, gray and paletted images are kept as is
+		if g, ok := m.(*image.Gray); ok {
+			m = grayToPaletted(g)
 		}
+		err = gif.Encode(&b, m, nil)
+	default:
+		err = jpeg.Encode(&b, m, &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// ImageResize resize image to specific width, height with the resampling filter, encoded as jpeg of quality
+func ImageResize(reader io.Reader, owidth, oheight, quality int, filter string) ([]byte, error) {
+	m, _, err := image.Decode(reader)
+	if err != nil {
+		return nil, err
 	}
 
+	newImg := Resample(m, owidth, oheight, filter)
+
 	var b bytes.Buffer
 	writer := bufio.NewWriter(&b)
-	opts := &jpeg.Options{Quality: 50}
+	opts := &jpeg.Options{Quality: quality}
 	jpeg.Encode(writer, newImg, opts)
 
 	return b.Bytes(), nil
@@ -0,0 +1,245 @@
+package main
+
+// in-memory inverted n-gram index for searching books by author and title
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BookIndex is an inverted index of rune unigrams and bigrams over book author and title.
+// keyword lookups intersect the posting lists of the keyword n-grams instead of scanning every book,
+// bigrams are used rather than trigrams so that short japanese keywords (e.g. 2 kanji) still hit the index
+type BookIndex struct {
+	docs  []*indexDoc        // indexed books, position is the doc id
+	grams map[string][]int32 // n-gram -> ascending doc ids
+	ids   map[string]int32   // book id -> doc id
+}
+
+// indexDoc is a book with its lower cased searchable text
+type indexDoc struct {
+	book   *Book
+	title  string // lower cased title
+	author string // lower cased author
+	text   string // lower cased author and title, what keywords are matched against
+}
+
+// searchResult is a matched book with its match quality score
+type searchResult struct {
+	doc   *indexDoc
+	score int
+}
+
+// NewBookIndex creates blank index
+func NewBookIndex() *BookIndex {
+	return &BookIndex{
+		grams: make(map[string][]int32),
+		ids:   make(map[string]int32),
+	}
+}
+
+// Len is number of indexed books
+func (idx *BookIndex) Len() int {
+	return len(idx.docs)
+}
+
+// Add indexes the book, book that already indexed by id will be skipped
+func (idx *BookIndex) Add(book *Book) {
+	if _, ok := idx.ids[book.ID]; ok {
+		return
+	}
+
+	doc := &indexDoc{
+		book:   book,
+		title:  strings.ToLower(book.Title),
+		author: strings.ToLower(book.Author),
+	}
+	doc.text = doc.author + " " + doc.title
+
+	docID := int32(len(idx.docs))
+	idx.docs = append(idx.docs, doc)
+	idx.ids[book.ID] = docID
+
+	for _, gram := range ngrams(doc.text, true) {
+		postings := idx.grams[gram]
+		// same n-gram can appear multiple times in a doc, doc ids are always ascending so only check the tail
+		if len(postings) > 0 && postings[len(postings)-1] == docID {
+			continue
+		}
+		idx.grams[gram] = append(postings, docID)
+	}
+}
+
+// Replace swaps in new copy of indexed book, title and author must be unchanged
+func (idx *BookIndex) Replace(book *Book) {
+	if docID, ok := idx.ids[book.ID]; ok {
+		idx.docs[docID].book = book
+	}
+}
+
+// Search finds books which author or title contains all the keywords (space separated, AND match),
+// result is ordered by match quality, best first. blank search returns all books in index order
+func (idx *BookIndex) Search(search string) []*Book {
+	keywords := StringSliceFlatten(strings.Split(strings.ToLower(search), " "))
+
+	if len(keywords) == 0 {
+		books := make([]*Book, len(idx.docs))
+		for i, doc := range idx.docs {
+			books[i] = doc.book
+		}
+		return books
+	}
+
+	// narrow down candidates by the rarest n-gram first
+	grams := []string{}
+	for _, keyword := range keywords {
+		grams = append(grams, ngrams(keyword, false)...)
+	}
+	sort.Slice(grams, func(i, j int) bool {
+		return len(idx.grams[grams[i]]) < len(idx.grams[grams[j]])
+	})
+
+	var candidates []int32
+	for i, gram := range grams {
+		postings := idx.grams[gram]
+		if i == 0 {
+			candidates = postings
+		} else {
+			candidates = intersectPostings(candidates, postings)
+		}
+		if len(candidates) == 0 {
+			return []*Book{}
+		}
+	}
+
+	// n-grams can all be present without forming the keyword, so verify and score each candidate
+	results := []searchResult{}
+OUTER:
+	for _, docID := range candidates {
+		doc := idx.docs[docID]
+
+		score := 0
+		for _, keyword := range keywords {
+			if !strings.Contains(doc.text, keyword) {
+				continue OUTER
+			}
+			score += matchScore(doc, keyword)
+		}
+
+		results = append(results, searchResult{doc: doc, score: score})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		// shorter title is closer to what was typed
+		if len(a.doc.title) != len(b.doc.title) {
+			return len(a.doc.title) < len(b.doc.title)
+		}
+		return AlphaNumCaseCompare(a.doc.book.Title+" "+a.doc.book.Number, b.doc.book.Title+" "+b.doc.book.Number)
+	})
+
+	books := make([]*Book, len(results))
+	for i, result := range results {
+		books[i] = result.doc.book
+	}
+
+	return books
+}
+
+// matchScore rates how well the keyword matches the book, higher is better
+func matchScore(doc *indexDoc, keyword string) int {
+	score := 0
+
+	switch {
+	case doc.title == keyword:
+		score += 100
+	case strings.HasPrefix(doc.title, keyword):
+		score += 60
+	case containsWord(doc.title, keyword):
+		score += 40
+	case strings.Contains(doc.title, keyword):
+		score += 25
+	}
+
+	switch {
+	case doc.author == keyword:
+		score += 50
+	case containsWord(doc.author, keyword):
+		score += 20
+	case strings.Contains(doc.author, keyword):
+		score += 10
+	}
+
+	return score
+}
+
+// containsWord checks if keyword appears in text starting at a word boundary
+func containsWord(text, keyword string) bool {
+	i := 0
+	for {
+		j := strings.Index(text[i:], keyword)
+		if j < 0 {
+			return false
+		}
+		pos := i + j
+		if pos == 0 {
+			return true
+		}
+		prev := []rune(text[:pos])
+		if r := prev[len(prev)-1]; unicode.IsSpace(r) || unicode.IsPunct(r) {
+			return true
+		}
+		i = pos + len(keyword)
+		if i >= len(text) {
+			return false
+		}
+	}
+}
+
+// ngrams splits text into rune bigrams, single rune text gives unigram.
+// withUnigrams also includes all unigrams, needed on indexing so single character keywords can be looked up
+func ngrams(text string, withUnigrams bool) []string {
+	runes := []rune(text)
+	grams := []string{}
+
+	if len(runes) == 1 || withUnigrams {
+		for _, r := range runes {
+			if unicode.IsSpace(r) {
+				continue
+			}
+			grams = append(grams, string(r))
+		}
+	}
+
+	for i := 0; i+1 < len(runes); i++ {
+		if unicode.IsSpace(runes[i]) || unicode.IsSpace(runes[i+1]) {
+			continue
+		}
+		grams = append(grams, string(runes[i:i+2]))
+	}
+
+	return grams
+}
+
+// intersectPostings gives doc ids that are in both ascending posting lists
+func intersectPostings(a, b []int32) []int32 {
+	out := []int32{}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, a[i])
+			i++
+			j++
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return out
+}
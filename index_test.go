@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBookIndexSearch(t *testing.T) {
+	idx := NewBookIndex()
+	for _, book := range []*Book{
+		{ID: "a", Author: "Oda Eiichiro", Title: "One Piece", Number: "1"},
+		{ID: "b", Author: "Oda Eiichiro", Title: "One Piece", Number: "2"},
+		{ID: "c", Author: "Toriyama Akira", Title: "Dragon Ball"},
+		{ID: "d", Author: "Toriyama Akira", Title: "Dr. Slump"},
+		{ID: "e", Author: "尾田栄一郎", Title: "ワンピース"},
+		{ID: "f", Author: "Someone", Title: "Pieces of Dragon"},
+		{ID: "g", Author: "Ball Author", Title: "Piece"},
+	} {
+		idx.Add(book)
+	}
+	// already indexed
+	idx.Add(&Book{ID: "a", Title: "Other"})
+
+	tests := []struct {
+		name   string
+		search string
+		want   []string // book ids in order
+	}{
+		{"blank gives all in index order", "", []string{"a", "b", "c", "d", "e", "f", "g"}},
+		{"spaces only", "   ", []string{"a", "b", "c", "d", "e", "f", "g"}},
+		{"case insensitive", "DRAGON", []string{"c", "f"}},
+		{"exact title, then prefix, then word", "piece", []string{"g", "f", "a", "b"}},
+		{"all keywords must match", "dragon ball", []string{"c"}},
+		{"keywords across author and title", "toriyama slump", []string{"d"}},
+		{"author", "oda", []string{"a", "b"}},
+		{"single character", "z", []string{}},
+		{"japanese bigram", "尾田", []string{"e"}},
+		{"japanese single rune", "ス", []string{"e"}},
+		{"no match", "naruto", []string{}},
+		{"n-grams present but not keyword", "ecep", []string{}},
+	}
+
+	for _, tt := range tests {
+		got := []string{}
+		for _, book := range idx.Search(tt.search) {
+			got = append(got, book.ID)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Search(%q) = %v, want %v", tt.name, tt.search, got, tt.want)
+		}
+	}
+
+	if idx.Len() != 7 {
+		t.Errorf("Len() = %d, want 7", idx.Len())
+	}
+}
+
+func TestContainsWord(t *testing.T) {
+	tests := []struct {
+		text, keyword string
+		want          bool
+	}{
+		{"one piece", "one", true},
+		{"one piece", "piece", true},
+		{"one piece", "iece", false},
+		{"dr. slump", "slump", true},
+		{"x-men", "men", true},
+		{"women men", "men", true},
+		{"women", "men", false},
+		{"ワンピース", "ピース", false},
+	}
+
+	for _, tt := range tests {
+		got := containsWord(tt.text, tt.keyword)
+		if got != tt.want {
+			t.Errorf("containsWord(%q, %q) = %t, want %t", tt.text, tt.keyword, got, tt.want)
+		}
+	}
+}
+
+func TestIntersectPostings(t *testing.T) {
+	tests := []struct {
+		a, b, want []int32
+	}{
+		{[]int32{1, 3, 5, 7}, []int32{2, 3, 4, 7, 9}, []int32{3, 7}},
+		{[]int32{1, 2}, []int32{3, 4}, []int32{}},
+		{nil, []int32{1}, []int32{}},
+		{[]int32{1, 2, 3}, []int32{1, 2, 3}, []int32{1, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		got := intersectPostings(tt.a, tt.b)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("intersectPostings(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+// client ip allow and deny lists, checked before authentication
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// lanCIDRs are loopback, private and link local ranges, allowed when public access is off
+var lanCIDRs = []string{
+	"127.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"169.254.0.0/16",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+// IPFilter decides which clients may connect
+type IPFilter struct {
+	allow   []*net.IPNet // when not empty, only these are allowed
+	deny    []*net.IPNet // always refused
+	proxies []*net.IPNet // trusted to set X-Forwarded-For
+	lan     []*net.IPNet // allowed when allow list is empty and public access is off
+	public  bool         // allow public addresses when allow list is empty
+}
+
+// parseCIDRs parses list of CIDR or single ip address
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, errors.New("invalid ip address " + s)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets, nil
+}
+
+// NewIPFilter creates ip filter from config
+func NewIPFilter(cfg *Config) (*IPFilter, error) {
+	f := &IPFilter{public: cfg.AllowPublic}
+
+	var err error
+	f.allow, err = parseCIDRs(cfg.AllowIPs)
+	if err != nil {
+		return nil, errors.New("allow_ips: " + err.Error())
+	}
+	f.deny, err = parseCIDRs(cfg.DenyIPs)
+	if err != nil {
+		return nil, errors.New("deny_ips: " + err.Error())
+	}
+	f.proxies, err = parseCIDRs(cfg.TrustedProxies)
+	if err != nil {
+		return nil, errors.New("trusted_proxies: " + err.Error())
+	}
+	f.lan, _ = parseCIDRs(lanCIDRs)
+
+	return f, nil
+}
+
+// ipInNets checks if ip is in any of the nets
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP gives ip of the client. X-Forwarded-For is only followed when the connection is from trusted proxy,
+// and is walked from the nearest hop so a client cannot pretend to be another address
+func (f *IPFilter) ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ipInNets(ip, f.proxies) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !ipInNets(ip, f.proxies) {
+			break
+		}
+	}
+	return ip
+}
+
+// Allowed checks if client ip may connect
+func (f *IPFilter) Allowed(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if ipInNets(ip, f.deny) {
+		return false
+	}
+	if len(f.allow) > 0 {
+		return ipInNets(ip, f.allow)
+	}
+	if f.public {
+		return true
+	}
+	return ipInNets(ip, f.lan)
+}
+
+// IPFilterHandler is middleware to refuse clients not allowed by the ip filter, runs before authentication
+func IPFilterHandler(h http.Handler, f *IPFilter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := f.ClientIP(r)
+		if !f.Allowed(ip) {
+			log.Println("refused", ip, r.RemoteAddr, r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Forbidden!"))
+			return
+		}
+
+		// resolved client ip for the rest of the request
+		r = r.WithContext(context.WithValue(r.Context(), ctxKeyIP, ip.String()))
+		h.ServeHTTP(w, r)
+	})
+}
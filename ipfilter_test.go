@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPFilterClientIP(t *testing.T) {
+	f, err := NewIPFilter(&Config{TrustedProxies: []string{"10.0.0.1", "192.168.1.0/24"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string // X-Forwarded-For headers
+		want   string
+	}{
+		{"direct client", "203.0.113.5:1234", nil, "203.0.113.5"},
+		{"untrusted client cannot forward", "203.0.113.5:1234", []string{"1.2.3.4"}, "203.0.113.5"},
+		{"trusted proxy", "10.0.0.1:1234", []string{"1.2.3.4"}, "1.2.3.4"},
+		{"spoofed hop before client is ignored", "10.0.0.1:1234", []string{"6.6.6.6, 1.2.3.4"}, "1.2.3.4"},
+		{"chain of trusted proxies", "10.0.0.1:1234", []string{"1.2.3.4, 192.168.1.7"}, "1.2.3.4"},
+		{"only trusted hops", "10.0.0.1:1234", []string{"192.168.1.7"}, "192.168.1.7"},
+		{"several headers", "10.0.0.1:1234", []string{"6.6.6.6", "1.2.3.4"}, "1.2.3.4"},
+		{"proxy without header", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"junk header", "10.0.0.1:1234", []string{"junk"}, "10.0.0.1"},
+		{"junk before client", "10.0.0.1:1234", []string{"junk, 1.2.3.4"}, "1.2.3.4"},
+		{"ipv6 client", "[2001:db8::1]:1234", []string{"1.2.3.4"}, "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		for _, v := range tt.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+
+		got := f.ClientIP(r)
+		if got.String() != tt.want {
+			t.Errorf("%s: ClientIP() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIPFilterAllowed(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *Config
+		ip   string
+		want bool
+	}{
+		{"lan by default", &Config{}, "192.168.0.5", true},
+		{"loopback by default", &Config{}, "127.0.0.1", true},
+		{"ipv6 loopback by default", &Config{}, "::1", true},
+		{"public refused by default", &Config{}, "8.8.8.8", false},
+		{"public allowed", &Config{AllowPublic: true}, "8.8.8.8", true},
+		{"denied before public", &Config{AllowPublic: true, DenyIPs: []string{"8.8.8.0/24"}}, "8.8.8.8", false},
+		{"denied before lan", &Config{DenyIPs: []string{"192.168.0.5"}}, "192.168.0.5", false},
+		{"in allow list", &Config{AllowIPs: []string{"203.0.113.0/24"}}, "203.0.113.9", true},
+		{"lan not in allow list", &Config{AllowIPs: []string{"203.0.113.0/24"}}, "192.168.0.5", false},
+		{"denied in allow list", &Config{AllowIPs: []string{"203.0.113.0/24"}, DenyIPs: []string{"203.0.113.9"}}, "203.0.113.9", false},
+		{"no ip", &Config{AllowPublic: true}, "", false},
+	}
+
+	for _, tt := range tests {
+		f, err := NewIPFilter(tt.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := f.Allowed(net.ParseIP(tt.ip))
+		if got != tt.want {
+			t.Errorf("%s: Allowed(%s) = %t, want %t", tt.name, tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestNewIPFilterInvalid(t *testing.T) {
+	tests := []*Config{
+		{AllowIPs: []string{"300.1.1.1"}},
+		{DenyIPs: []string{"10.0.0.0/33"}},
+		{TrustedProxies: []string{"proxy"}},
+	}
+
+	for _, cfg := range tests {
+		if _, err := NewIPFilter(cfg); err == nil {
+			t.Errorf("NewIPFilter(%v %v %v) gave no error", cfg.AllowIPs, cfg.DenyIPs, cfg.TrustedProxies)
+		}
+	}
+}
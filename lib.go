@@ -2,16 +2,17 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
 	"crypto/sha256"
 	"fmt"
+	"io/ioutil"
 	"math"
-	"math/rand"
 	"os"
 	"os/user"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
-	"time"
 )
 
 // UserHome builds path with user home path
@@ -47,18 +48,72 @@ func StringSliceContain(strSlice []string, strSearch string) bool {
 	return false
 }
 
-// GenerateString create random new string for a certain length
-// https://stackoverflow.com/questions/22892120/how-to-generate-a-random-string-of-a-fixed-length-in-go
+// PathInDirs checks if the path is one of the dirs or inside one, whole path components are matched
+// so /books/kids does not give /books/kids2
+func PathInDirs(dirs []string, fpath string) bool {
+	fpath = filepath.Clean(strings.Replace(fpath, "\\", "/", -1))
+	for _, dir := range dirs {
+		dir = filepath.Clean(strings.Replace(dir, "\\", "/", -1))
+		if fpath == dir || strings.HasPrefix(fpath, strings.TrimSuffix(dir, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeFileAtomic writes data to a temp file next to fpath then renames it over fpath, so the file is never
+// seen half written, even after a crash. temp file name is unique, overlapping writes cannot mix
+func writeFileAtomic(fpath string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(fpath), filepath.Base(fpath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, fpath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
+
+// GenerateString create cryptographically secure random new string for a certain length,
+// used for session id, salt and token
 func GenerateString(n int) string {
 	// only alpha-numeric
 	const letterBytes = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-
-	// slightly less deterministic randomness
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	// largest multiple of 62 below 256, bytes above are discarded so every letter is equally likely
+	const maxByte = 256 - 256%len(letterBytes)
 
 	b := make([]byte, n)
-	for i := range b {
-		b[i] = letterBytes[r.Intn(len(letterBytes))]
+	buf := make([]byte, n)
+	i := 0
+	for i < n {
+		_, err := rand.Read(buf)
+		if err != nil {
+			// no safe fallback for secrets
+			panic(err)
+		}
+		for _, c := range buf {
+			if int(c) >= maxByte {
+				continue
+			}
+			b[i] = letterBytes[int(c)%len(letterBytes)]
+			i++
+			if i == n {
+				break
+			}
+		}
 	}
 	return string(b)
 }
@@ -79,18 +134,17 @@ func SHA256Iter(password, salt string, iter int) string {
 
 // NewUUIDV4 generate uuid version 4
 func NewUUIDV4() (string, error) {
-	str := strings.Split("0123456789abcdef", "")
-	a := []string{}
-
-	t := time.Now().UnixNano()
-	rand.Seed(t)
-
-	for i := 0; i < 36; i++ {
-		a = append(a, str[rand.Intn(16)])
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
 	}
-	b := strings.Join(a, "")
 
-	uuid := fmt.Sprintf("%s-%s-%s-%s-%s", b[0:8], b[9:13], b[14:18], b[19:23], b[24:36])
+	// version 4, variant 10
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	uuid := fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
 
 	return uuid, nil
 }
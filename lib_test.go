@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPathInDirs(t *testing.T) {
+	tests := []struct {
+		name  string
+		dirs  []string
+		fpath string
+		want  bool
+	}{
+		{"dir itself", []string{"/books/kids"}, "/books/kids", true},
+		{"file in dir", []string{"/books/kids"}, "/books/kids/a.cbz", true},
+		{"file in subdir", []string{"/books/kids"}, "/books/kids/x/a.cbz", true},
+		{"sibling with same prefix", []string{"/books/kids"}, "/books/kids2/a.cbz", false},
+		{"sibling dir with same prefix", []string{"/books/kids"}, "/books/kids2", false},
+		{"parent", []string{"/books/kids"}, "/books", false},
+		{"dot dot out of dir", []string{"/books/kids"}, "/books/kids/../adult/a.cbz", false},
+		{"dot dot within dir", []string{"/books/kids"}, "/books/kids/x/../a.cbz", true},
+		{"dir with trailing slash", []string{"/books/kids/"}, "/books/kids/a.cbz", true},
+		{"dir with trailing slash and sibling", []string{"/books/kids/"}, "/books/kids2/a.cbz", false},
+		{"path with trailing slash", []string{"/books/kids"}, "/books/kids/", true},
+		{"root dir", []string{"/"}, "/books/a.cbz", true},
+		{"backslashes", []string{"C:\\books\\kids"}, "C:\\books\\kids\\a.cbz", true},
+		{"backslashes and sibling", []string{"C:\\books\\kids"}, "C:\\books\\kids2\\a.cbz", false},
+		{"second dir", []string{"/books/kids", "/comics"}, "/comics/a.cbz", true},
+		{"no dirs", nil, "/books/kids/a.cbz", false},
+	}
+
+	for _, tt := range tests {
+		got := PathInDirs(tt.dirs, tt.fpath)
+		if got != tt.want {
+			t.Errorf("%s: PathInDirs(%q, %q) = %t, want %t", tt.name, tt.dirs, tt.fpath, got, tt.want)
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fpath := filepath.Join(dir, "config.json")
+
+	for _, dat := range []string{"first", "second, longer than first", "3rd"} {
+		err := writeFileAtomic(fpath, []byte(dat), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadFile(fpath)
+		if err != nil || string(got) != dat {
+			t.Errorf("file has %q %v, want %q", got, err, dat)
+		}
+	}
+
+	// no temp file left behind
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 {
+		t.Errorf("%d files in dir, want 1", len(fis))
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, "nodir", "x"), []byte("x"), 0600); err == nil {
+		t.Error("write into missing dir gave no error")
+	}
+}
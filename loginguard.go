@@ -0,0 +1,271 @@
+package main
+
+// login brute-force protection, failed attempts are tracked per ip and per username
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// login guard tuning
+var (
+	LoginFreeAttempts    = 3                // failures allowed before backoff starts
+	LoginBackoffBase     = time.Second      // first backoff delay, doubled on each further failure
+	LoginLockoutAttempts = 10               // failures before temporary lockout
+	LoginLockoutTime     = 15 * time.Minute // lockout duration, also max backoff
+	LoginForgetTime      = 24 * time.Hour   // failures are forgotten after no attempt for this long
+	LoginTrackMax        = 10000            // ips and usernames tracked at most, made up usernames cannot fill memory
+)
+
+// LoginGuard tracks failed login attempts and blocks further attempts for a while
+type LoginGuard struct {
+	mutex    sync.Mutex
+	attempts map[string]*LoginAttempt // attempts by key, see loginKeyIP and loginKeyUser
+	file     gobFile                  // file to persist to, blank path for in-memory only
+}
+
+// LoginAttempt is failed login history of an ip or username
+type LoginAttempt struct {
+	Key      string    // ip:{addr} or user:{username}
+	Failures int       // consecutive failures
+	Last     time.Time // last failure
+	Until    time.Time // blocked until
+}
+
+// Blocked is attempt still blocked
+func (la *LoginAttempt) Blocked() bool {
+	return la.Until.After(time.Now())
+}
+
+// loginKeyIP key for tracking ip
+func loginKeyIP(ip string) string {
+	return "ip:" + ip
+}
+
+// loginKeyUser key for tracking username
+func loginKeyUser(username string) string {
+	return "user:" + username
+}
+
+// NewLoginGuard creates login guard, path is where attempts persist, blank to keep in memory only
+func NewLoginGuard(path string) *LoginGuard {
+	return &LoginGuard{
+		attempts: make(map[string]*LoginAttempt),
+		file:     gobFile{path: path},
+	}
+}
+
+// loginBackoff gives how long to block after n consecutive failures
+func loginBackoff(n int) time.Duration {
+	if n >= LoginLockoutAttempts {
+		return LoginLockoutTime
+	}
+	if n <= LoginFreeAttempts {
+		return 0
+	}
+
+	d := LoginBackoffBase << uint(n-LoginFreeAttempts-1)
+	if d > LoginLockoutTime {
+		d = LoginLockoutTime
+	}
+	return d
+}
+
+// Check gives how long until login is allowed for the ip and username, 0 if allowed now.
+// allowed attempt is counted as failed right away so parallel attempts cannot get past the limit,
+// Success clears it when the password is right
+func (lg *LoginGuard) Check(ip, username string) time.Duration {
+	lg.mutex.Lock()
+	defer lg.mutex.Unlock()
+
+	now := time.Now()
+	wait := time.Duration(0)
+	for _, key := range []string{loginKeyIP(ip), loginKeyUser(username)} {
+		la := lg.attempts[key]
+		if la != nil && la.Until.After(now) && la.Until.Sub(now) > wait {
+			wait = la.Until.Sub(now)
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+
+	// reserve the attempt
+	for _, key := range []string{loginKeyIP(ip), loginKeyUser(username)} {
+		la := lg.attempts[key]
+		if la == nil || now.Sub(la.Last) > LoginForgetTime {
+			if la == nil && len(lg.attempts) >= LoginTrackMax {
+				lg.forgetOldest()
+			}
+			la = &LoginAttempt{Key: key}
+			lg.attempts[key] = la
+		}
+		la.Failures++
+		la.Last = now
+		la.Until = now.Add(loginBackoff(la.Failures))
+	}
+	lg.file.changed()
+
+	return 0
+}
+
+// forgetOldest drops attempt with the oldest failure, blocked ones are kept if possible. caller holds the lock
+func (lg *LoginGuard) forgetOldest() {
+	var oldest *LoginAttempt
+	for _, la := range lg.attempts {
+		if oldest == nil || (oldest.Blocked() && !la.Blocked()) ||
+			(oldest.Blocked() == la.Blocked() && la.Last.Before(oldest.Last)) {
+			oldest = la
+		}
+	}
+	if oldest != nil {
+		delete(lg.attempts, oldest.Key)
+		lg.file.changed()
+	}
+}
+
+// Failure logs failed login, it was counted by Check already
+func (lg *LoginGuard) Failure(ip, username string) {
+	lg.mutex.Lock()
+	defer lg.mutex.Unlock()
+
+	now := time.Now()
+	failures := []int{}
+	wait := time.Duration(0)
+	for _, key := range []string{loginKeyIP(ip), loginKeyUser(username)} {
+		la := lg.attempts[key]
+		if la == nil {
+			// unblocked by admin meanwhile
+			failures = append(failures, 0)
+			continue
+		}
+		failures = append(failures, la.Failures)
+		if la.Until.Sub(now) > wait {
+			wait = la.Until.Sub(now)
+		}
+	}
+
+	log.Printf("login failed %q from %s, failures ip %d user %d, blocked for %s\n", username, ip, failures[0], failures[1], wait)
+}
+
+// Success clears failures of the ip and username
+func (lg *LoginGuard) Success(ip, username string) {
+	lg.mutex.Lock()
+	defer lg.mutex.Unlock()
+
+	for _, key := range []string{loginKeyIP(ip), loginKeyUser(username)} {
+		if lg.attempts[key] != nil {
+			delete(lg.attempts, key)
+			lg.file.changed()
+		}
+	}
+}
+
+// Unblock clears failures of the key
+func (lg *LoginGuard) Unblock(key string) {
+	lg.mutex.Lock()
+	defer lg.mutex.Unlock()
+
+	delete(lg.attempts, key)
+	lg.file.changed()
+}
+
+// Blocked lists currently blocked ips and usernames, longest block first
+func (lg *LoginGuard) Blocked() []LoginAttempt {
+	lg.mutex.Lock()
+	defer lg.mutex.Unlock()
+
+	list := []LoginAttempt{}
+	for _, la := range lg.attempts {
+		if la.Blocked() {
+			list = append(list, *la)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Until.After(list[j].Until)
+	})
+	return list
+}
+
+// Scrub forgets failures that are old
+func (lg *LoginGuard) Scrub() {
+	lg.mutex.Lock()
+	defer lg.mutex.Unlock()
+
+	now := time.Now()
+	for key, la := range lg.attempts {
+		if now.Sub(la.Last) > LoginForgetTime && !la.Blocked() {
+			delete(lg.attempts, key)
+			lg.file.changed()
+		}
+	}
+}
+
+// save writes attempts to file when persistence is enabled
+func (lg *LoginGuard) save() error {
+	return lg.file.save(&lg.mutex, func(enc *gob.Encoder) error {
+		return enc.Encode(lg.attempts)
+	})
+}
+
+// Load reads previously saved attempts when persistence is enabled
+func (lg *LoginGuard) Load() error {
+	if lg.file.path == "" {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(lg.file.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	attempts := make(map[string]*LoginAttempt)
+	err = gob.NewDecoder(bytes.NewBuffer(b)).Decode(&attempts)
+	if err != nil {
+		return err
+	}
+
+	lg.mutex.Lock()
+	lg.attempts = attempts
+	lg.mutex.Unlock()
+
+	lg.Scrub()
+	return nil
+}
+
+// Run scrubs old failures and saves changes periodically, it does not return
+func (lg *LoginGuard) Run() {
+	for range time.Tick(SessionSaveInterval) {
+		lg.Scrub()
+
+		lg.mutex.Lock()
+		dirty := lg.file.dirty()
+		lg.mutex.Unlock()
+		if !dirty {
+			continue
+		}
+
+		err := lg.save()
+		if err != nil {
+			log.Println("failed to save login attempts", err)
+		}
+	}
+}
+
+// loginAttemptsFile is where login attempts are persisted
+func loginAttemptsFile(cfg *Config) string {
+	if !cfg.PersistLoginAttempts {
+		return ""
+	}
+	return filepath.Join(cfg.PathDir, "login_attempts")
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{LoginFreeAttempts, 0},
+		{LoginFreeAttempts + 1, LoginBackoffBase},
+		{LoginFreeAttempts + 2, 2 * LoginBackoffBase},
+		{LoginFreeAttempts + 3, 4 * LoginBackoffBase},
+		{LoginLockoutAttempts - 1, LoginBackoffBase << uint(LoginLockoutAttempts-LoginFreeAttempts-2)},
+		{LoginLockoutAttempts, LoginLockoutTime},
+		{LoginLockoutAttempts + 5, LoginLockoutTime},
+	}
+
+	for _, tt := range tests {
+		got := loginBackoff(tt.failures)
+		if got != tt.want {
+			t.Errorf("loginBackoff(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginGuardCheck(t *testing.T) {
+	lg := NewLoginGuard("")
+
+	// free attempts are allowed, then blocked
+	for i := 0; i < LoginFreeAttempts; i++ {
+		if wait := lg.Check("1.2.3.4", "alice"); wait != 0 {
+			t.Fatalf("attempt %d blocked for %s", i+1, wait)
+		}
+		lg.Failure("1.2.3.4", "alice")
+	}
+	if wait := lg.Check("1.2.3.4", "alice"); wait != 0 {
+		t.Fatalf("last free attempt blocked for %s", wait)
+	}
+	lg.Failure("1.2.3.4", "alice")
+
+	tests := []struct {
+		name    string
+		ip      string
+		user    string
+		blocked bool
+	}{
+		{"same ip and user", "1.2.3.4", "alice", true},
+		{"same ip other user", "1.2.3.4", "bob", true},
+		{"other ip same user", "5.6.7.8", "alice", true},
+		{"other ip and user", "5.6.7.8", "bob", false},
+	}
+	for _, tt := range tests {
+		wait := lg.Check(tt.ip, tt.user)
+		if (wait > 0) != tt.blocked {
+			t.Errorf("%s: Check() = %s, want blocked %t", tt.name, wait, tt.blocked)
+		}
+	}
+
+	if n := len(lg.Blocked()); n != 2 {
+		t.Errorf("Blocked() gives %d, want 2", n)
+	}
+
+	lg.Unblock(loginKeyIP("1.2.3.4"))
+	lg.Unblock(loginKeyUser("alice"))
+	if wait := lg.Check("1.2.3.4", "alice"); wait != 0 {
+		t.Errorf("blocked for %s after unblock", wait)
+	}
+}
+
+func TestLoginGuardSuccess(t *testing.T) {
+	lg := NewLoginGuard("")
+
+	for i := 0; i < LoginFreeAttempts; i++ {
+		lg.Check("1.2.3.4", "alice")
+		lg.Failure("1.2.3.4", "alice")
+	}
+	lg.Check("1.2.3.4", "alice")
+	lg.Success("1.2.3.4", "alice")
+
+	// failures start over
+	for i := 0; i <= LoginFreeAttempts; i++ {
+		if wait := lg.Check("1.2.3.4", "alice"); wait != 0 {
+			t.Fatalf("attempt %d after success blocked for %s", i+1, wait)
+		}
+		lg.Failure("1.2.3.4", "alice")
+	}
+}
+
+func TestLoginGuardParallel(t *testing.T) {
+	lg := NewLoginGuard("")
+
+	// attempts at once still get one free attempt each, the rest wait
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	allowed := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if lg.Check("1.2.3.4", "alice") == 0 {
+				mutex.Lock()
+				allowed++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != LoginFreeAttempts+1 {
+		t.Errorf("%d parallel attempts allowed, want %d", allowed, LoginFreeAttempts+1)
+	}
+}
+
+func TestLoginGuardPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loginguard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fpath := filepath.Join(dir, "login_attempts")
+
+	lg := NewLoginGuard(fpath)
+	for i := 0; i <= LoginFreeAttempts; i++ {
+		lg.Check("1.2.3.4", "alice")
+		lg.Failure("1.2.3.4", "alice")
+	}
+	if err := lg.save(); err != nil {
+		t.Fatal(err)
+	}
+	if lg.file.dirty() {
+		t.Error("changes still pending after save")
+	}
+
+	lg2 := NewLoginGuard(fpath)
+	if err := lg2.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if wait := lg2.Check("1.2.3.4", "bob"); wait == 0 {
+		t.Error("ip not blocked after load")
+	}
+}
+
+func TestLoginGuardTrackMax(t *testing.T) {
+	defer func(n int) { LoginTrackMax = n }(LoginTrackMax)
+	LoginTrackMax = 10
+	lg := NewLoginGuard("")
+
+	// locked out user is kept while made up usernames come and go
+	for i := 0; i < LoginLockoutAttempts; i++ {
+		lg.Check("1.2.3.4", "alice")
+	}
+	for i := 0; i < 50; i++ {
+		lg.Check(fmt.Sprintf("10.0.0.%d", i), fmt.Sprintf("user%d", i))
+	}
+
+	if n := len(lg.attempts); n > LoginTrackMax {
+		t.Errorf("%d attempts tracked, want at most %d", n, LoginTrackMax)
+	}
+	if wait := lg.Check("5.6.7.8", "alice"); wait == 0 {
+		t.Error("locked out user forgotten")
+	}
+}
@@ -1,22 +1,111 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net"
 	"net/http"
 	"strings"
 )
 
-// LoggedIn keyword for session
-var LoggedIn = "LoggedIn"
+// SessionUsername keyword for session, holds the logged in username
+var SessionUsername = "Username"
+
+// context key for values attached to request
+type ctxKey int
+
+const (
+	ctxKeyUser    ctxKey = iota // logged in *User
+	ctxKeySession               // request scoped *session, for clients without cookie
+	ctxKeyScope                 // api token scope, blank when not using token
+	ctxKeyIP                    // client ip resolved by ip filter
+)
+
+// requestUser gives the logged in user of the request, nil if not logged in
+func requestUser(r *http.Request) *User {
+	u, _ := r.Context().Value(ctxKeyUser).(*User)
+	return u
+}
+
+// requestCanWrite can the request change reading progress, guest and read only api token cannot
+func requestCanWrite(r *http.Request) bool {
+	user := requestUser(r)
+	if user == nil || !user.CanWrite() {
+		return false
+	}
+	scope, _ := r.Context().Value(ctxKeyScope).(string)
+	return scope != ScopeRead
+}
+
+// clientIP gives ip address of the client
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(ctxKeyIP).(string); ok {
+		return ip
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// sessionUser finds the logged in user from session
+func sessionUser(httpSession *SessionStore, cfg *Config, w http.ResponseWriter, r *http.Request) *User {
+	username, ok := httpSession.Get(w, r, SessionUsername).(string)
+	if !ok {
+		return nil
+	}
+	// user could have been removed from config
+	return cfg.User(username)
+}
 
 // CheckAuthHandler is middleware to check and make sure user is logged in
 // ref https://cryptic.io/go-http/
-func CheckAuthHandler(h http.Handler, httpSession *SessionStore, cfg *Config) http.Handler {
+func CheckAuthHandler(h http.Handler, httpSession *SessionStore, cfg *Config, basicAuth *BasicAuth) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// api token, only for api
+		if token := requestToken(r); token != "" && strings.Contains(r.URL.Path, "/api/") {
+			user, t := cfg.UserByToken(token)
+			if user == nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("Unauthorised!"))
+				return
+			}
+			if !tokenRoute(r.URL.Path) || t.Scope == ScopeRead && r.Method != "GET" && r.Method != "HEAD" {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("Forbidden!"))
+				return
+			}
+			ctx := context.WithValue(r.Context(), ctxKeyUser, user)
+			ctx = context.WithValue(ctx, ctxKeyScope, t.Scope)
+			ctx = context.WithValue(ctx, ctxKeySession, newRequestSession(r))
+			r = r.WithContext(ctx)
+		} else if user, ok := basicAuth.User(r); ok {
+			// basic auth, checked on every request
+			if user == nil {
+				basicAuth.Challenge(w)
+				return
+			}
+			ctx := context.WithValue(r.Context(), ctxKeyUser, user)
+			// client without cookie would make a new stored session on every request
+			if _, err := r.Cookie("SessionID"); err != nil {
+				ctx = context.WithValue(ctx, ctxKeySession, newRequestSession(r))
+			}
+			r = r.WithContext(ctx)
+		}
+
 		// initialise session
 		_ = httpSession.ID(w, r)
 		// fmt.Println("method:", r.Method, "url: ", r.URL.Path, "session", sid)
 
+		// attach logged in user so handlers can resolve per user data
+		if requestUser(r) == nil {
+			if user := sessionUser(httpSession, cfg, w, r); user != nil {
+				r = r.WithContext(context.WithValue(r.Context(), ctxKeyUser, user))
+			}
+		}
+
 		// http root path
 		switch r.URL.Path {
 		case "/":
@@ -32,17 +121,35 @@ func CheckAuthHandler(h http.Handler, httpSession *SessionStore, cfg *Config) ht
 		case "/legacy.html":
 			getPage(httpSession, cfg, h)(w, r)
 			return
+		case "/admin.html":
+			getPage(httpSession, cfg, h)(w, r)
+			return
+		case "/account.html":
+			getPage(httpSession, cfg, h)(w, r)
+			return
+		case "/settings.html":
+			getPage(httpSession, cfg, h)(w, r)
+			return
 		}
 
 		// private
 		if strings.Contains(r.URL.Path, "/api/") {
 			// get session detail
-			value := httpSession.Get(w, r, LoggedIn)
-			if value != true {
+			user := requestUser(r)
+			if user == nil {
+				if basicAuth != nil {
+					basicAuth.Challenge(w)
+					return
+				}
 				w.WriteHeader(http.StatusUnauthorized)
 				w.Write([]byte("Unauthorised!"))
 				return
 			}
+			if !roleAllowed(user, r) {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("Forbidden!"))
+				return
+			}
 
 			h.ServeHTTP(w, r)
 			return
@@ -54,10 +161,44 @@ func CheckAuthHandler(h http.Handler, httpSession *SessionStore, cfg *Config) ht
 	})
 }
 
+// tokenRoutes api paths that can be used with api token, the rest need login
+var tokenRoutes = []string{"/api/read/", "/api/thumbnail/", "/api/bookmark", "/api/fav", "/api/ranking"}
+
+// tokenRoute checks if the api path can be used with api token
+func tokenRoute(path string) bool {
+	for _, route := range tokenRoutes {
+		if strings.HasSuffix(route, "/") && strings.HasPrefix(path, route) || path == route {
+			return true
+		}
+	}
+	return false
+}
+
+// roleAllowed checks the user role is allowed to make the request
+func roleAllowed(user *User, r *http.Request) bool {
+	// admin page and api
+	if r.URL.Path == "/admin.html" || strings.HasPrefix(r.URL.Path, "/api/admin/") {
+		return user.IsAdmin()
+	}
+
+	// changing favourite or ranking
+	if r.URL.Path == "/api/fav" || r.URL.Path == "/api/ranking" {
+		return requestCanWrite(r)
+	}
+
+	return true
+}
+
 // echo http events
 func svrLogging(h http.Handler, httpSession *SessionStore, cfg *Config) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Println(r.Method, r.URL.Path, r.URL.RawQuery)
+		rawQuery := r.URL.RawQuery
+		// dont leak api token to log
+		if query := r.URL.Query(); query.Get("token") != "" {
+			query.Set("token", "***")
+			rawQuery = query.Encode()
+		}
+		log.Println(r.Method, r.URL.Path, rawQuery)
 
 		h.ServeHTTP(w, r)
 		return
@@ -0,0 +1,318 @@
+package main
+
+// disk cache of rendered pages, so resized pages are not re-encoded on every visit
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPageCacheSize page cache size in MB when config dont specify
+const DefaultPageCacheSize = 256
+
+// PageCache is size bounded LRU cache of rendered pages under cache dir.
+// files are named {bookID}/{mtime}-{page}-{render key}, so a changed book never hits old pages
+type PageCache struct {
+	mutex   sync.Mutex
+	dir     string
+	maxSize int64                    // bytes, evicts least recently used above this
+	size    int64                    // bytes of all cached pages
+	entries map[string]*list.Element // by file path relative to dir
+	lru     *list.List               // most recently used at front
+	mtimes  map[string]int64         // book mtime the cached pages are from, by book id
+	stats   PageCacheStats
+}
+
+// pageCacheEntry is a cached page file
+type pageCacheEntry struct {
+	name   string // file path relative to cache dir
+	bookID string
+	size   int64
+}
+
+// PageCacheStats is usage of the page cache
+type PageCacheStats struct {
+	Pages       int    // pages in cache
+	Size        int64  // bytes in cache
+	MaxSize     int64  // bytes allowed
+	Hits        uint64 // pages served from cache
+	Misses      uint64 // pages rendered
+	Evictions   uint64 // pages removed to stay under max size
+	Evicted     int64  // bytes removed to stay under max size
+	Invalidated uint64 // pages removed because book changed
+}
+
+// NewPageCache creates page cache in dir holding up to maxSize bytes
+func NewPageCache(dir string, maxSize int64) *PageCache {
+	return &PageCache{
+		dir:     dir,
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		mtimes:  make(map[string]int64),
+	}
+}
+
+// Key gives short key of the rendering options, part of cached page file name
+func (opt PageOptions) Key() string {
+	s := fmt.Sprintf("%d %d %s %d %t %t %s", opt.MaxWidth, opt.MaxHeight, opt.Filter, opt.Quality, opt.Grayscale, opt.Crop, opt.Format)
+	if opt.EInk != nil {
+		s += fmt.Sprintf(" eink %d %g %g", opt.EInk.Levels, opt.EInk.Gamma, opt.EInk.Contrast)
+	}
+
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:6])
+}
+
+// pageCacheName file path of cached page relative to cache dir
+func pageCacheName(bookID string, mtime int64, page int, key string) string {
+	return filepath.Join(bookID, strconv.FormatInt(mtime, 10)+"-"+strconv.Itoa(page)+"-"+key)
+}
+
+// Load indexes pages already on disk, oldest modified are evicted first
+func (pc *PageCache) Load() error {
+	err := os.MkdirAll(pc.dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	type found struct {
+		name, bookID string
+		mtime        int64
+		size         int64
+		modTime      time.Time
+	}
+	files := []found{}
+
+	dirs, err := ioutil.ReadDir(pc.dir)
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		fis, err := ioutil.ReadDir(filepath.Join(pc.dir, d.Name()))
+		if err != nil {
+			return err
+		}
+		for _, fi := range fis {
+			if strings.HasSuffix(fi.Name(), ".tmp") {
+				// write cut short, e.g. power loss
+				os.Remove(filepath.Join(pc.dir, d.Name(), fi.Name()))
+				continue
+			}
+			parts := strings.SplitN(fi.Name(), "-", 3)
+			if len(parts) != 3 {
+				continue
+			}
+			mtime, err := strconv.ParseInt(parts[0], 10, 64)
+			if err != nil {
+				continue
+			}
+			files = append(files, found{
+				name:    filepath.Join(d.Name(), fi.Name()),
+				bookID:  d.Name(),
+				mtime:   mtime,
+				size:    fi.Size(),
+				modTime: fi.ModTime(),
+			})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	for _, f := range files {
+		// pages of another copy of the book, most recently used copy is kept
+		pc.checkMtime(f.bookID, f.mtime)
+		pc.add(&pageCacheEntry{name: f.name, bookID: f.bookID, size: f.size})
+	}
+	pc.evict()
+
+	return nil
+}
+
+// add puts entry as most recently used, caller holds the lock
+func (pc *PageCache) add(e *pageCacheEntry) {
+	if old, ok := pc.entries[e.name]; ok {
+		pc.size -= old.Value.(*pageCacheEntry).size
+		pc.lru.Remove(old)
+	}
+	pc.entries[e.name] = pc.lru.PushFront(e)
+	pc.size += e.size
+}
+
+// remove deletes entry and its file, caller holds the lock
+func (pc *PageCache) remove(el *list.Element) {
+	e := pc.lru.Remove(el).(*pageCacheEntry)
+	delete(pc.entries, e.name)
+	pc.size -= e.size
+
+	err := os.Remove(filepath.Join(pc.dir, e.name))
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("failed to remove cached page", e.name, err)
+	}
+}
+
+// evict removes least recently used pages until under max size, caller holds the lock
+func (pc *PageCache) evict() {
+	for pc.size > pc.maxSize {
+		el := pc.lru.Back()
+		if el == nil {
+			return
+		}
+		pc.stats.Evictions++
+		pc.stats.Evicted += el.Value.(*pageCacheEntry).size
+		pc.remove(el)
+	}
+}
+
+// invalidate removes all cached pages of the book, caller holds the lock
+func (pc *PageCache) invalidate(bookID string) {
+	for el := pc.lru.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*pageCacheEntry).bookID == bookID {
+			pc.stats.Invalidated++
+			pc.remove(el)
+		}
+		el = next
+	}
+	delete(pc.mtimes, bookID)
+	os.Remove(filepath.Join(pc.dir, bookID))
+}
+
+// checkMtime drops cached pages of the book when its mtime differs, caller holds the lock.
+// older mtime is a change too, e.g. book restored from backup
+func (pc *PageCache) checkMtime(bookID string, mtime int64) {
+	if known, ok := pc.mtimes[bookID]; ok && known != mtime {
+		pc.invalidate(bookID)
+	}
+	pc.mtimes[bookID] = mtime
+}
+
+// current checks cached pages of the book are of the mtime, caller holds the lock
+func (pc *PageCache) current(bookID string, mtime int64) bool {
+	known, ok := pc.mtimes[bookID]
+	return ok && known == mtime
+}
+
+// Get gives cached page, book mtime different from the cached pages drops them
+func (pc *PageCache) Get(bookID string, mtime int64, page int, key string) ([]byte, bool) {
+	name := pageCacheName(bookID, mtime, page, key)
+
+	pc.mutex.Lock()
+	pc.checkMtime(bookID, mtime)
+	el, ok := pc.entries[name]
+	if !ok {
+		pc.stats.Misses++
+		pc.mutex.Unlock()
+		return nil, false
+	}
+	pc.lru.MoveToFront(el)
+	pc.mutex.Unlock()
+
+	fpath := filepath.Join(pc.dir, name)
+	dat, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		// removed behind our back
+		pc.mutex.Lock()
+		if el, ok := pc.entries[name]; ok {
+			pc.remove(el)
+		}
+		pc.stats.Misses++
+		pc.mutex.Unlock()
+		return nil, false
+	}
+
+	// keep use order across restart
+	now := time.Now()
+	os.Chtimes(fpath, now, now)
+
+	pc.mutex.Lock()
+	pc.stats.Hits++
+	pc.mutex.Unlock()
+	return dat, true
+}
+
+// Put stores rendered page, pages bigger than the whole cache are not kept.
+// mtime is of the book when rendering started, page is dropped when Get saw another mtime since
+func (pc *PageCache) Put(bookID string, mtime int64, page int, key string, dat []byte) error {
+	if int64(len(dat)) > pc.maxSize {
+		return nil
+	}
+	name := pageCacheName(bookID, mtime, page, key)
+	fpath := filepath.Join(pc.dir, name)
+
+	pc.mutex.Lock()
+	current := pc.current(bookID, mtime)
+	pc.mutex.Unlock()
+	if !current {
+		// book changed since, page is of no use
+		return nil
+	}
+
+	// write whole file before it is visible
+	err := os.MkdirAll(filepath.Dir(fpath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(fpath, dat, 0644)
+	if err != nil {
+		return err
+	}
+
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	if !pc.current(bookID, mtime) {
+		// book changed while writing
+		os.Remove(fpath)
+		return nil
+	}
+	pc.add(&pageCacheEntry{name: name, bookID: bookID, size: int64(len(dat))})
+	pc.evict()
+
+	return nil
+}
+
+// Invalidate removes all cached pages of the book
+func (pc *PageCache) Invalidate(bookID string) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	pc.invalidate(bookID)
+}
+
+// Clear removes all cached pages
+func (pc *PageCache) Clear() {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	for el := pc.lru.Front(); el != nil; el = pc.lru.Front() {
+		pc.remove(el)
+	}
+	for bookID := range pc.mtimes {
+		os.Remove(filepath.Join(pc.dir, bookID))
+	}
+	pc.mtimes = make(map[string]int64)
+}
+
+// Stats gives usage of the cache
+func (pc *PageCache) Stats() PageCacheStats {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	stats := pc.stats
+	stats.Pages = pc.lru.Len()
+	stats.Size = pc.size
+	stats.MaxSize = pc.maxSize
+	return stats
+}
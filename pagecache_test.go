@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestPageCache creates page cache in temp dir, removed by the returned func
+func newTestPageCache(t *testing.T, maxSize int64) (*PageCache, func()) {
+	dir, err := ioutil.TempDir("", "pagecache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc := NewPageCache(dir, maxSize)
+	err = pc.Load()
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return pc, func() { os.RemoveAll(dir) }
+}
+
+// putPage renders page as a visit does, miss on Get then Put
+func putPage(t *testing.T, pc *PageCache, bookID string, mtime int64, page int, dat string) {
+	if _, ok := pc.Get(bookID, mtime, page, "k"); ok {
+		t.Fatalf("page %s %d %d already cached", bookID, mtime, page)
+	}
+	err := pc.Put(bookID, mtime, page, "k", []byte(dat))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPageCacheEviction(t *testing.T) {
+	pc, cleanup := newTestPageCache(t, 10)
+	defer cleanup()
+
+	putPage(t, pc, "b1", 1, 1, "1111")
+	putPage(t, pc, "b1", 1, 2, "2222")
+	// above 10 bytes, least recently used page 1 of b1 evicted
+	putPage(t, pc, "b2", 1, 1, "3333")
+	// page 2 of b1 used, page 1 of b2 is now least recently used
+	if _, ok := pc.Get("b1", 1, 2, "k"); !ok {
+		t.Fatal("page 2 not cached")
+	}
+	putPage(t, pc, "b2", 1, 2, "4444")
+	// bigger than the whole cache, not kept
+	putPage(t, pc, "b2", 1, 3, "55555555555")
+
+	tests := []struct {
+		bookID string
+		page   int
+		want   string
+	}{
+		{"b1", 1, ""},
+		{"b1", 2, "2222"},
+		{"b2", 1, ""},
+		{"b2", 2, "4444"},
+		{"b2", 3, ""},
+	}
+	for _, tt := range tests {
+		dat, ok := pc.Get(tt.bookID, 1, tt.page, "k")
+		if ok != (tt.want != "") || string(dat) != tt.want {
+			t.Errorf("Get(%s, %d) = %q %t, want %q", tt.bookID, tt.page, dat, ok, tt.want)
+		}
+		fpath := filepath.Join(pc.dir, pageCacheName(tt.bookID, 1, tt.page, "k"))
+		if _, err := os.Stat(fpath); os.IsNotExist(err) != (tt.want == "") {
+			t.Errorf("%s page %d: file exists %t", tt.bookID, tt.page, err == nil)
+		}
+	}
+
+	stats := pc.Stats()
+	if stats.Pages != 2 || stats.Size != 8 || stats.Evictions != 2 || stats.Evicted != 8 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestPageCacheMtime(t *testing.T) {
+	tests := []struct {
+		name    string
+		getTime int64 // mtime of the book on next visit
+		putTime int64 // mtime of a render started before the visit
+		hit     bool  // pages of mtime 5 still cached
+		late    bool  // late render is cached
+	}{
+		{"unchanged", 5, 5, true, true},
+		{"newer book", 6, 5, false, false},
+		{"older book, e.g. restored from backup", 4, 5, false, false},
+		{"render of the new book", 6, 6, false, true},
+	}
+
+	for _, tt := range tests {
+		pc, cleanup := newTestPageCache(t, 100)
+
+		putPage(t, pc, "b", 5, 1, "page")
+		pc.Get("b", tt.getTime, 2, "k")
+		err := pc.Put("b", tt.putTime, 3, "k", []byte("late"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Get would itself change the mtime, look at entries
+		pc.mutex.Lock()
+		_, hit := pc.entries[pageCacheName("b", 5, 1, "k")]
+		_, late := pc.entries[pageCacheName("b", tt.putTime, 3, "k")]
+		pc.mutex.Unlock()
+		if hit != tt.hit {
+			t.Errorf("%s: first page cached %t, want %t", tt.name, hit, tt.hit)
+		}
+		if late != tt.late {
+			t.Errorf("%s: late page cached %t, want %t", tt.name, late, tt.late)
+		}
+
+		cleanup()
+	}
+}
+
+func TestPageCacheLoad(t *testing.T) {
+	pc, cleanup := newTestPageCache(t, 100)
+	defer cleanup()
+
+	putPage(t, pc, "b1", 1, 1, "1111")
+	putPage(t, pc, "b1", 1, 2, "2222")
+	// write cut short
+	err := ioutil.WriteFile(filepath.Join(pc.dir, "b1", "1-3-k.123.tmp"), []byte("33"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewPageCache(pc.dir, 100)
+	err = loaded.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := loaded.Stats()
+	if stats.Pages != 2 || stats.Size != 8 {
+		t.Errorf("loaded %d pages of %d bytes, want 2 of 8", stats.Pages, stats.Size)
+	}
+	if dat, ok := loaded.Get("b1", 1, 2, "k"); !ok || string(dat) != "2222" {
+		t.Errorf("Get() = %q %t after load", dat, ok)
+	}
+	if _, err := os.Stat(filepath.Join(pc.dir, "b1", "1-3-k.123.tmp")); !os.IsNotExist(err) {
+		t.Error("temp file not removed on load")
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+// page loading for the reader, recent and upcoming pages are kept in memory
+// and the next pages are warmed in the background while the current one is read
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// page prefetch tuning
+var (
+	DefaultPrefetchPages = 3        // pages ahead to warm when config dont specify
+	PrefetchWorkers      = 1        // background loaders, low power server cannot afford more
+	PrefetchQueue        = 16       // waiting prefetch jobs, more are dropped
+	PageMemorySize       = 64 << 20 // bytes of pages kept in memory
+	PageLoaders          = 2        // pages read and rendered at once, each can take tens of MB
+)
+
+// memCache is size bounded LRU of page data in memory
+type memCache struct {
+	mutex   sync.Mutex
+	maxSize int64
+	size    int64
+	entries map[string]*list.Element
+	lru     *list.List // most recently used at front
+}
+
+// memCacheEntry is a page in memory
+type memCacheEntry struct {
+	key string
+	dat []byte
+}
+
+// newMemCache creates memory cache holding up to maxSize bytes
+func newMemCache(maxSize int64) *memCache {
+	return &memCache{
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Get gives page in memory
+func (mc *memCache) Get(key string) ([]byte, bool) {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	el, ok := mc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	mc.lru.MoveToFront(el)
+	return el.Value.(*memCacheEntry).dat, true
+}
+
+// Put keeps page in memory, least recently used are dropped to make room
+func (mc *memCache) Put(key string, dat []byte) {
+	if int64(len(dat)) > mc.maxSize {
+		return
+	}
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	if el, ok := mc.entries[key]; ok {
+		mc.size -= int64(len(el.Value.(*memCacheEntry).dat))
+		mc.lru.Remove(el)
+	}
+	mc.entries[key] = mc.lru.PushFront(&memCacheEntry{key: key, dat: dat})
+	mc.size += int64(len(dat))
+
+	for mc.size > mc.maxSize {
+		e := mc.lru.Remove(mc.lru.Back()).(*memCacheEntry)
+		delete(mc.entries, e.key)
+		mc.size -= int64(len(e.dat))
+	}
+}
+
+// PageReader loads book pages, from memory, page cache or the book itself
+type PageReader struct {
+	pageCache *PageCache // rendered pages on disk, nil when disabled
+	memory    *memCache  // recent and prefetched pages
+	ahead     int        // pages to prefetch after the one read, 0 to disable
+
+	mutex   sync.Mutex
+	loading map[string]chan struct{} // pages being loaded, closed when done
+	jobs    chan pageJob
+	slots   chan struct{} // bounds pages in memory being read and rendered
+}
+
+// pageJob is a page to prefetch
+type pageJob struct {
+	book   *Book
+	page   int
+	opt    PageOptions
+	render bool
+}
+
+// NewPageReader creates page reader prefetching ahead pages, starts the background workers
+func NewPageReader(pageCache *PageCache, ahead int) *PageReader {
+	pr := &PageReader{
+		pageCache: pageCache,
+		memory:    newMemCache(int64(PageMemorySize)),
+		ahead:     ahead,
+		loading:   make(map[string]chan struct{}),
+		jobs:      make(chan pageJob, PrefetchQueue),
+		slots:     make(chan struct{}, PageLoaders),
+	}
+	for i := 0; i < PrefetchWorkers; i++ {
+		go pr.run()
+	}
+
+	return pr
+}
+
+// run loads queued pages into memory
+func (pr *PageReader) run() {
+	for job := range pr.jobs {
+		_, err := pr.Page(job.book, job.page, job.opt, job.render)
+		if err != nil {
+			fmt.Println("failed to prefetch page", job.book.ID, job.page, err)
+		}
+	}
+}
+
+// Prefetch queues the pages after page to be loaded in background, skipped when queue is full
+func (pr *PageReader) Prefetch(book *Book, page int, opt PageOptions, render bool) {
+	for p := page + 1; p <= page+pr.ahead && p <= int(book.Pages); p++ {
+		select {
+		case pr.jobs <- pageJob{book: book, page: p, opt: opt, render: render}:
+		default:
+			// reader is ahead of the workers, dont pile up
+			return
+		}
+	}
+}
+
+// pageKey gives key of how page is rendered, orig for original image
+func pageKey(opt PageOptions, render bool) string {
+	if !render {
+		return "orig"
+	}
+	return opt.Key()
+}
+
+// Cached gives page when it is in memory already, e.g. prefetched
+func (pr *PageReader) Cached(book *Book, mtime int64, page int, opt PageOptions, render bool) ([]byte, bool) {
+	return pr.memory.Get(pageCacheName(book.ID, mtime, page, pageKey(opt, render)))
+}
+
+// Page gives page of the book. page is rendered with the options when render is set, otherwise original image is given
+func (pr *PageReader) Page(book *Book, page int, opt PageOptions, render bool) ([]byte, error) {
+	// pages of changed book are not reused
+	fstat, err := os.Stat(book.Fullpath)
+	if err != nil {
+		return nil, err
+	}
+	mtime := fstat.ModTime().Unix()
+	key := pageKey(opt, render)
+	name := pageCacheName(book.ID, mtime, page, key)
+
+	for {
+		if dat, ok := pr.memory.Get(name); ok {
+			return dat, nil
+		}
+
+		// wait when it is being loaded already, e.g. prefetch of the page just tapped to
+		pr.mutex.Lock()
+		wait, ok := pr.loading[name]
+		if !ok {
+			done := make(chan struct{})
+			pr.loading[name] = done
+			pr.mutex.Unlock()
+
+			dat, err := pr.load(book, mtime, page, key, opt, render)
+			if err == nil {
+				pr.memory.Put(name, dat)
+			}
+
+			pr.mutex.Lock()
+			delete(pr.loading, name)
+			pr.mutex.Unlock()
+			close(done)
+
+			return dat, err
+		}
+		pr.mutex.Unlock()
+		<-wait
+	}
+}
+
+// load reads page from page cache or the book, rendered pages are kept in page cache
+func (pr *PageReader) load(book *Book, mtime int64, page int, key string, opt PageOptions, render bool) ([]byte, error) {
+	if render && pr.pageCache != nil {
+		if dat, ok := pr.pageCache.Get(book.ID, mtime, page, key); ok {
+			return dat, nil
+		}
+	}
+
+	// page is read and decoded in memory, only a few at once
+	pr.slots <- struct{}{}
+	defer func() { <-pr.slots }()
+
+	imgDat, err := cbzPage(book.Fullpath, page)
+	if err != nil || !render {
+		return imgDat, err
+	}
+
+	rendered, err := RenderPage(imgDat, opt)
+	if err != nil {
+		// still readable, just not optimised
+		fmt.Println("failed to render page", book.ID, page, err)
+		return imgDat, nil
+	}
+
+	if pr.pageCache != nil {
+		err = pr.pageCache.Put(book.ID, mtime, page, key, rendered)
+		if err != nil {
+			fmt.Println("failed to cache page", book.ID, page, err)
+		}
+	}
+	return rendered, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+// Pagination describe which slice of the listing is shown, used by browse template for navigation
+type Pagination struct {
+	Page    int // current page, starts at 1
+	Pages   int // total number of pages, at least 1
+	PerPage int // items per page
+	Total   int // total number of items in listing
+	First   int // index of first item shown, starts at 1 (0 if listing is empty)
+	Last    int // index of last item shown
+}
+
+// HasPrev is there a page before current page
+func (p Pagination) HasPrev() bool {
+	return p.Page > 1
+}
+
+// HasNext is there a page after current page
+func (p Pagination) HasNext() bool {
+	return p.Page < p.Pages
+}
+
+// PrevPage page number before current page
+func (p Pagination) PrevPage() int {
+	if p.Page <= 1 {
+		return 1
+	}
+	return p.Page - 1
+}
+
+// NextPage page number after current page
+func (p Pagination) NextPage() int {
+	if p.Page >= p.Pages {
+		return p.Pages
+	}
+	return p.Page + 1
+}
+
+// paginate chops the already sorted listing to the requested page.
+// page out of range is clamped to the first or last page
+func paginate(list FileList, page, perPage int) (FileList, Pagination) {
+	if perPage < 1 {
+		perPage = ItemsPerPage
+	}
+
+	total := len(list)
+	pages := (total + perPage - 1) / perPage
+	if pages < 1 {
+		pages = 1
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	if page > pages {
+		page = pages
+	}
+
+	head := (page - 1) * perPage
+	tail := head + perPage
+	if tail > total {
+		tail = total
+	}
+
+	pg := Pagination{
+		Page:    page,
+		Pages:   pages,
+		PerPage: perPage,
+		Total:   total,
+		Last:    tail,
+	}
+	if total > 0 {
+		pg.First = head + 1
+	}
+
+	return list[head:tail], pg
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPaginate(t *testing.T) {
+	tests := []struct {
+		name    string
+		total   int
+		page    int
+		perPage int
+		want    Pagination
+		items   int
+		hasNext bool
+	}{
+		{"empty", 0, 1, 10, Pagination{Page: 1, Pages: 1, PerPage: 10, Total: 0, First: 0, Last: 0}, 0, false},
+		{"less than a page", 5, 1, 10, Pagination{Page: 1, Pages: 1, PerPage: 10, Total: 5, First: 1, Last: 5}, 5, false},
+		{"exactly one full page", 10, 1, 10, Pagination{Page: 1, Pages: 1, PerPage: 10, Total: 10, First: 1, Last: 10}, 10, false},
+		{"first of exactly full pages", 20, 1, 10, Pagination{Page: 1, Pages: 2, PerPage: 10, Total: 20, First: 1, Last: 10}, 10, true},
+		{"exactly full last page", 20, 2, 10, Pagination{Page: 2, Pages: 2, PerPage: 10, Total: 20, First: 11, Last: 20}, 10, false},
+		{"one more than full pages", 21, 2, 10, Pagination{Page: 2, Pages: 3, PerPage: 10, Total: 21, First: 11, Last: 20}, 10, true},
+		{"partial last page", 21, 3, 10, Pagination{Page: 3, Pages: 3, PerPage: 10, Total: 21, First: 21, Last: 21}, 1, false},
+		{"page beyond last", 20, 9, 10, Pagination{Page: 2, Pages: 2, PerPage: 10, Total: 20, First: 11, Last: 20}, 10, false},
+		{"page before first", 20, 0, 10, Pagination{Page: 1, Pages: 2, PerPage: 10, Total: 20, First: 1, Last: 10}, 10, true},
+		{"default page size", ItemsPerPage, 1, 0, Pagination{Page: 1, Pages: 1, PerPage: ItemsPerPage, Total: ItemsPerPage, First: 1, Last: ItemsPerPage}, ItemsPerPage, false},
+	}
+
+	for _, tt := range tests {
+		list := FileList{}
+		for i := 0; i < tt.total; i++ {
+			list = append(list, &FileInfoBasic{})
+		}
+
+		items, got := paginate(list, tt.page, tt.perPage)
+		if got != tt.want {
+			t.Errorf("%s: paginate() = %+v, want %+v", tt.name, got, tt.want)
+		}
+		if len(items) != tt.items {
+			t.Errorf("%s: %d items, want %d", tt.name, len(items), tt.items)
+		}
+		if got.HasNext() != tt.hasNext {
+			t.Errorf("%s: HasNext() = %t, want %t", tt.name, got.HasNext(), tt.hasNext)
+		}
+		if len(items) > 0 && items[0] != list[got.First-1] {
+			t.Errorf("%s: first item is not item %d", tt.name, got.First)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+// password hashing, kept in-tree so no external dependency is needed
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+)
+
+// password hash algorithms, stored with the user so old hashes can still be verified
+const (
+	HashSHA256Iter   = "sha256iter"    // legacy, iterated sha256 of password:salt
+	HashPBKDF2SHA256 = "pbkdf2-sha256" // PBKDF2 (RFC 8018) with HMAC-SHA256
+)
+
+// HashAlgorithm is the algorithm used for new password hashes
+const HashAlgorithm = HashPBKDF2SHA256
+
+// PBKDF2 computes key of keyLen bytes from password and salt, using HMAC-SHA256 as the pseudorandom function
+func PBKDF2(password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var buf [4]byte
+	dk := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		// U1 = PRF(password, salt || INT(block))
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], uint32(block))
+		prf.Write(buf[:])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+
+		// T = U1 ^ U2 ^ ... ^ Uc
+		for n := 2; n <= iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = u[:0]
+			u = prf.Sum(u)
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+
+	return dk[:keyLen]
+}
+
+// passwordHash computes hex hash of password with the algorithm
+func passwordHash(algo, password, salt string, iter int) (string, error) {
+	switch algo {
+	case "", HashSHA256Iter:
+		return SHA256Iter(password, salt, iter), nil
+	case HashPBKDF2SHA256:
+		return fmt.Sprintf("%x", PBKDF2([]byte(password), []byte(salt), iter, sha256.Size)), nil
+	}
+
+	return "", fmt.Errorf("unknown password hash algorithm %q", algo)
+}
+
+// passwordMatch checks password against stored hash in constant time
+func passwordMatch(algo, password, salt string, iter int, crypt string) bool {
+	strCrypt, err := passwordHash(algo, password, salt, iter)
+	if err != nil || crypt == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(strCrypt), []byte(crypt)) == 1
+}
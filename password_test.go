@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// RFC 7914 section 11 and widely published PBKDF2-HMAC-SHA256 vectors
+	tests := []struct {
+		password, salt string
+		iter, keyLen   int
+		want           string
+	}{
+		{"password", "salt", 1, 32, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, 32, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, 32, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, 40, "348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1c635518c7dac47e9"},
+		{"pass\x00word", "sa\x00lt", 4096, 16, "89b69d0516f829893c696226650a8687"},
+		{"passwd", "salt", 1, 64, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, 64, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+
+	for _, tt := range tests {
+		got := hex.EncodeToString(PBKDF2([]byte(tt.password), []byte(tt.salt), tt.iter, tt.keyLen))
+		if got != tt.want {
+			t.Errorf("PBKDF2(%q, %q, %d, %d) = %s, want %s", tt.password, tt.salt, tt.iter, tt.keyLen, got, tt.want)
+		}
+	}
+}
+
+func TestPasswordHash(t *testing.T) {
+	tests := []struct {
+		algo    string
+		want    string
+		wantErr bool
+	}{
+		// legacy, sha256 of "pass123:salty" three times
+		{"", "4972cca9b7522ce3325311451cfc36329f48cb6863cf45e19e7694832526d319", false},
+		{HashSHA256Iter, "4972cca9b7522ce3325311451cfc36329f48cb6863cf45e19e7694832526d319", false},
+		{HashPBKDF2SHA256, hex.EncodeToString(PBKDF2([]byte("pass123"), []byte("salty"), 3, 32)), false},
+		{"md5", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := passwordHash(tt.algo, "pass123", "salty", 3)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("passwordHash(%q) error = %v, want error %t", tt.algo, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("passwordHash(%q) = %s, want %s", tt.algo, got, tt.want)
+		}
+	}
+}
+
+func TestPasswordMatch(t *testing.T) {
+	crypt, _ := passwordHash(HashPBKDF2SHA256, "pass123", "salty", 10)
+
+	tests := []struct {
+		name     string
+		algo     string
+		password string
+		iter     int
+		crypt    string
+		want     bool
+	}{
+		{"right password", HashPBKDF2SHA256, "pass123", 10, crypt, true},
+		{"wrong password", HashPBKDF2SHA256, "pass124", 10, crypt, false},
+		{"wrong iterations", HashPBKDF2SHA256, "pass123", 11, crypt, false},
+		{"wrong algorithm", HashSHA256Iter, "pass123", 10, crypt, false},
+		{"unknown algorithm", "md5", "pass123", 10, crypt, false},
+		{"blank crypt", HashPBKDF2SHA256, "pass123", 10, "", false},
+	}
+
+	for _, tt := range tests {
+		got := passwordMatch(tt.algo, tt.password, "salty", tt.iter, tt.crypt)
+		if got != tt.want {
+			t.Errorf("%s: passwordMatch() = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
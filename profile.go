@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// DeviceProfile holds settings tailored to a kind of client device, chosen per session
+type DeviceProfile struct {
+	Name         string `json:"name"`                 // unique profile name
+	ItemsPerPage int    `json:"items_per_page"`       // browse listing page size
+	MaxWidth     int    `json:"max_width,omitempty"`  // reader page images are downscaled to fit, 0 for no limit
+	MaxHeight    int    `json:"max_height,omitempty"` // reader page images are downscaled to fit, 0 for no limit
+	Format       string `json:"format,omitempty"`     // page format, jpeg, png or gif. blank for jpeg (png for e-ink)
+	Quality      int    `json:"quality,omitempty"`    // jpeg quality, 0 for config image_quality
+	Grayscale    bool   `json:"grayscale,omitempty"`  // grayscale pages, saves bandwidth on monochrome screen
+	Template     string `json:"template,omitempty"`   // browse page template, css or legacy. blank for css
+
+	EInk       bool    `json:"eink,omitempty"`        // grayscale dithered pages for e-ink display
+	GrayLevels int     `json:"gray_levels,omitempty"` // e-ink gray levels, 4 for kindle 1, 16 for kindle 2 and DX
+	Gamma      float64 `json:"gamma,omitempty"`       // e-ink gamma, above 1 lightens mid tones
+	Contrast   float64 `json:"contrast,omitempty"`    // e-ink contrast, above 1 more contrast
+
+	UserAgents []string `json:"user_agents,omitempty"` // suggested for browser user agent containing any of these, case insensitive
+}
+
+// browse page templates
+const (
+	TemplateCSS    = "css"    // modern browsers
+	TemplateLegacy = "legacy" // table layout for old browsers
+)
+
+// SessionProfile keyword for session, holds chosen device profile name
+var SessionProfile = "Profile"
+
+// DefaultProfileName is the profile used when none is chosen
+const DefaultProfileName = "default"
+
+// Profile get device profile by name, unknown name gives the default profile
+func (cfg *Config) Profile(name string) *DeviceProfile {
+	for _, p := range cfg.Profiles {
+		if p.Name == name {
+			return p
+		}
+	}
+	for _, p := range cfg.Profiles {
+		if p.Name == DefaultProfileName {
+			return p
+		}
+	}
+
+	return &DeviceProfile{
+		Name:         DefaultProfileName,
+		ItemsPerPage: cfg.ItemsPerPage,
+	}
+}
+
+// validate checks the profile settings are usable
+func (p *DeviceProfile) validate() error {
+	if p.Name == "" {
+		return errors.New("profile name cannot be blank")
+	}
+	switch p.Format {
+	case "", FormatJPEG, FormatPNG, FormatGIF:
+	default:
+		return errors.New(p.Name + ": format must be jpeg, png or gif")
+	}
+	if p.Quality < 0 || p.Quality > 100 {
+		return errors.New(p.Name + ": quality must be 1-100")
+	}
+	if p.GrayLevels != 0 && (p.GrayLevels < 2 || p.GrayLevels > 256) {
+		return errors.New(p.Name + ": gray levels must be 2-256")
+	}
+	switch p.Template {
+	case "", TemplateCSS, TemplateLegacy:
+	default:
+		return errors.New(p.Name + ": template must be css or legacy")
+	}
+	return nil
+}
+
+// SuggestProfile finds profile made for the browser user agent, nil if none
+func (cfg *Config) SuggestProfile(userAgent string) *DeviceProfile {
+	ua := strings.ToLower(userAgent)
+	for _, p := range cfg.Profiles {
+		for _, s := range p.UserAgents {
+			if s != "" && strings.Contains(ua, strings.ToLower(s)) {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// sessionProfile get device profile chosen for the session, the choice is changed only by settings POST.
+// when nothing is chosen, profile suggested by user agent is used
+func sessionProfile(cfg *Config, httpSession *SessionStore, w http.ResponseWriter, r *http.Request) *DeviceProfile {
+	name, _ := httpSession.Get(w, r, SessionProfile).(string)
+	if name == "" {
+		if p := cfg.SuggestProfile(r.UserAgent()); p != nil {
+			return p
+		}
+	}
+
+	return cfg.Profile(name)
+}
+
+// PageOptions gives how reader pages are rendered for the profile
+func (p *DeviceProfile) PageOptions(cfg *Config) PageOptions {
+	opt := PageOptions{
+		Filter:    cfg.ImageFilter,
+		Quality:   cfg.ImageQuality,
+		Format:    p.Format,
+		Grayscale: p.Grayscale,
+	}
+	if p.Quality > 0 {
+		opt.Quality = p.Quality
+	}
+	if cfg.ImageResize {
+		opt.MaxWidth = p.MaxWidth
+		opt.MaxHeight = p.MaxHeight
+	}
+	if p.EInk {
+		opt.EInk = &EInkOptions{
+			Levels:   p.GrayLevels,
+			Gamma:    p.Gamma,
+			Contrast: p.Contrast,
+		}
+		if opt.Format == "" || opt.Format == FormatJPEG {
+			// dithered few level image is much smaller and sharper in png
+			opt.Format = FormatPNG
+		}
+	}
+
+	return opt
+}
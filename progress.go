@@ -0,0 +1,313 @@
+package main
+
+// per user reading progress, stored separately from the book catalogue
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProgressRepeatTime same page of a book is not written again within this, page image and reader script both report it
+var ProgressRepeatTime = time.Minute
+
+// Progress is reading state of a book for a user
+type Progress struct {
+	BookID  string
+	Page    int64 // read upto
+	Fav     int64 // favourite, 0 false, 1 true
+	Ranking int64 // 1-5 ranking, least to most liked
+	Rtime   int64 // read time
+}
+
+// ProgressStore holds reading progress of all the users.
+// each user has own append only csv file, latest line of a book wins
+type ProgressStore struct {
+	mutex sync.Mutex
+	dir   string                   // where progress files are stored
+	users map[string]*UserProgress // loaded users by username
+}
+
+// UserProgress is reading progress of one user
+type UserProgress struct {
+	store    *ProgressStore
+	username string
+	path     string               // progress file path
+	books    map[string]*Progress // progress by book id
+	removed  bool                 // user deleted, nothing is written any more
+}
+
+// NewProgressStore creates progress store, files are kept in dir
+func NewProgressStore(dir string) (*ProgressStore, error) {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProgressStore{
+		dir:   dir,
+		users: make(map[string]*UserProgress),
+	}, nil
+}
+
+// For get progress of the user, load from file on first use
+func (ps *ProgressStore) For(username string) *UserProgress {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	up := ps.users[username]
+	if up != nil {
+		return up
+	}
+
+	up = &UserProgress{
+		store:    ps,
+		username: username,
+		path:     filepath.Join(ps.dir, username+".txt"),
+		books:    make(map[string]*Progress),
+	}
+	err := up.load()
+	if err != nil {
+		fmt.Println("failed to load progress of", username, err)
+	}
+	ps.users[username] = up
+
+	return up
+}
+
+// Remove forgets progress of the user and deletes the progress file, used when user is deleted
+func (ps *ProgressStore) Remove(username string) error {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	// requests of the user still running keep the loaded progress, but cannot write the file again
+	if up := ps.users[username]; up != nil {
+		up.removed = true
+		delete(ps.users, username)
+	}
+
+	err := os.Remove(filepath.Join(ps.dir, username+".txt"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Exists check if the user has progress file
+func (ps *ProgressStore) Exists(username string) bool {
+	isExist, _ := IsFileExists(filepath.Join(ps.dir, username+".txt"))
+	return isExist
+}
+
+// Seed copies progress stored in the book catalogue to the user, used when migrating from single user
+func (ps *ProgressStore) Seed(username string, db *FlatDB) error {
+	up := ps.For(username)
+
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	db.mutex.Lock()
+	for _, book := range db.books {
+		if book.Page <= 0 && book.Fav == 0 && book.Ranking == 0 && book.Rtime == 0 {
+			continue
+		}
+		up.books[book.ID] = &Progress{
+			BookID:  book.ID,
+			Page:    book.Page,
+			Fav:     book.Fav,
+			Ranking: book.Ranking,
+			Rtime:   book.Rtime,
+		}
+	}
+	db.mutex.Unlock()
+
+	return up.compact()
+}
+
+// load reads progress file, compacts when file has many outdated lines
+func (up *UserProgress) load() error {
+	f, err := os.Open(up.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p, err := csvToProgress(line)
+		if err != nil {
+			continue
+		}
+		up.books[p.BookID] = p
+		lines++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	f.Close()
+
+	if lines > 2*len(up.books)+100 {
+		return up.compact()
+	}
+
+	return nil
+}
+
+// compact rewrites progress file with only the latest progress of each book, caller must hold the lock
+func (up *UserProgress) compact() error {
+	buf := new(bytes.Buffer)
+	for _, p := range up.books {
+		buf.Write(progressToCSV(p))
+	}
+
+	return writeFileAtomic(up.path, buf.Bytes(), 0644)
+}
+
+// save appends latest progress of book to file, caller must hold the lock
+func (up *UserProgress) save(p *Progress) error {
+	if up.removed {
+		return nil
+	}
+
+	f, err := os.OpenFile(up.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(progressToCSV(p))
+	return err
+}
+
+// get progress of book, create blank one if not exists, caller must hold the lock
+func (up *UserProgress) get(bookID string) *Progress {
+	p := up.books[bookID]
+	if p == nil {
+		p = &Progress{BookID: bookID}
+		up.books[bookID] = p
+	}
+	return p
+}
+
+// Get progress of book, zero value if never read
+func (up *UserProgress) Get(bookID string) Progress {
+	up.store.mutex.Lock()
+	defer up.store.mutex.Unlock()
+
+	p := up.books[bookID]
+	if p == nil {
+		return Progress{BookID: bookID}
+	}
+	return *p
+}
+
+// Overlay replaces the reading state of book with the user progress, book should be a copy
+func (up *UserProgress) Overlay(book *Book) {
+	p := up.Get(book.ID)
+
+	book.Page = p.Page
+	book.Fav = p.Fav
+	book.Ranking = p.Ranking
+	book.Rtime = p.Rtime
+}
+
+// UpdatePage set page read upto
+func (up *UserProgress) UpdatePage(bookID string, page int) error {
+	up.store.mutex.Lock()
+	defer up.store.mutex.Unlock()
+
+	p := up.get(bookID)
+	now := time.Now().Unix()
+	if p.Page == int64(page) && time.Duration(now-p.Rtime)*time.Second < ProgressRepeatTime {
+		return nil
+	}
+	p.Page = int64(page)
+	p.Rtime = now
+
+	return up.save(p)
+}
+
+// UpdateFav set or unset book favourite
+func (up *UserProgress) UpdateFav(bookID string, fav bool) error {
+	up.store.mutex.Lock()
+	defer up.store.mutex.Unlock()
+
+	p := up.get(bookID)
+	if fav {
+		p.Fav = 1
+	} else {
+		p.Fav = 0
+	}
+
+	return up.save(p)
+}
+
+// UpdateRanking set book ranking, 0 to clear
+func (up *UserProgress) UpdateRanking(bookID string, ranking int) error {
+	if ranking < 0 || ranking > 5 {
+		return fmt.Errorf("invalid ranking %d, must be 0-5", ranking)
+	}
+
+	up.store.mutex.Lock()
+	defer up.store.mutex.Unlock()
+
+	p := up.get(bookID)
+	p.Ranking = int64(ranking)
+
+	return up.save(p)
+}
+
+// csvToProgress converts a CSV string to a Progress
+func csvToProgress(line string) (*Progress, error) {
+	r := csv.NewReader(strings.NewReader(line))
+	records, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) != 5 {
+		return nil, ErrCSVIncomplete
+	}
+
+	return &Progress{
+		BookID:  records[0],
+		Page:    mustInt64(records[1]),
+		Fav:     mustInt64(records[2]),
+		Ranking: mustInt64(records[3]),
+		Rtime:   mustInt64(records[4]),
+	}, nil
+}
+
+// progressToCSV convert Progress to csv bytes
+func progressToCSV(p *Progress) []byte {
+	// DO NOT change ordering, can only append in future
+	records := []string{
+		p.BookID,              // 0  BookID
+		fmt.Sprint(p.Page),    // 1  Page
+		fmt.Sprint(p.Fav),     // 2  Fav
+		fmt.Sprint(p.Ranking), // 3  Ranking
+		fmt.Sprint(p.Rtime),   // 4  Rtime
+	}
+
+	result := []string{}
+	for _, str := range records {
+		result = append(result, stringToCSVSafe(str))
+	}
+
+	return []byte(strings.Join(result, ",") + "\n")
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestProgressRemove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ps, err := NewProgressStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	up := ps.For("bob")
+	if err := up.UpdateFav("abc", true); err != nil {
+		t.Fatal(err)
+	}
+	if !ps.Exists("bob") {
+		t.Fatal("no progress file after update")
+	}
+
+	if err := ps.Remove("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if ps.Exists("bob") {
+		t.Error("progress file left after remove")
+	}
+
+	// request still holding old progress cannot write the file again
+	if err := up.UpdatePage("abc", 5); err != nil {
+		t.Fatal(err)
+	}
+	if ps.Exists("bob") {
+		t.Error("progress file written after remove")
+	}
+
+	// user created again starts afresh
+	if p := ps.For("bob").Get("abc"); p.Fav != 0 || p.Page != 0 {
+		t.Errorf("progress %+v kept after remove", p)
+	}
+
+	if err := ps.Remove("nobody"); err != nil {
+		t.Errorf("Remove() of user without progress gave %v", err)
+	}
+}
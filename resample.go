@@ -0,0 +1,264 @@
+package main
+
+// image resampling, works on the pixel buffers of typed images instead of per pixel At/Set interface calls
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// resampling filters
+const (
+	FilterNearest  = "nearest"  // fastest, jagged
+	FilterBilinear = "bilinear" // smooth, blurry when shrinking a lot
+	FilterArea     = "area"     // average of covered source pixels, best for shrinking
+	FilterLanczos  = "lanczos"  // lanczos3, sharpest, slowest
+)
+
+// DefaultFilter is the filter used when config dont specify
+const DefaultFilter = FilterArea
+
+// validFilter checks filter name is known
+func validFilter(name string) bool {
+	switch name {
+	case FilterNearest, FilterBilinear, FilterArea, FilterLanczos:
+		return true
+	}
+	return false
+}
+
+// contrib is the source pixels contributing to a destination pixel, weights sum to 1
+type contrib struct {
+	start   int // first source pixel
+	weights []float32
+}
+
+// kernel of convolution filter, support is the radius where the kernel is non zero
+type kernel struct {
+	support float64
+	at      func(x float64) float64
+}
+
+var (
+	kernelBilinear = kernel{1, func(x float64) float64 {
+		x = math.Abs(x)
+		if x < 1 {
+			return 1 - x
+		}
+		return 0
+	}}
+	kernelLanczos = kernel{3, func(x float64) float64 {
+		x = math.Abs(x)
+		if x == 0 {
+			return 1
+		}
+		if x >= 3 {
+			return 0
+		}
+		px := math.Pi * x
+		return 3 * math.Sin(px) * math.Sin(px/3) / (px * px)
+	}}
+)
+
+// makeContribs works out which source pixels make each destination pixel, when resizing a line of src to dst pixels
+func makeContribs(src, dst int, filter string) []contrib {
+	scale := float64(src) / float64(dst)
+	contribs := make([]contrib, dst)
+
+	// enlarging by area is same as bilinear
+	if filter == FilterArea && scale <= 1 {
+		filter = FilterBilinear
+	}
+
+	for i := range contribs {
+		switch filter {
+		case FilterNearest:
+			j := int((float64(i) + 0.5) * scale)
+			if j >= src {
+				j = src - 1
+			}
+			contribs[i] = contrib{start: j, weights: []float32{1}}
+
+		case FilterArea:
+			// exact coverage of each source pixel by destination pixel
+			lo, hi := float64(i)*scale, float64(i+1)*scale
+			start, end := int(lo), int(math.Ceil(hi))
+			if end > src {
+				end = src
+			}
+			weights := make([]float32, end-start)
+			for j := start; j < end; j++ {
+				weights[j-start] = float32((math.Min(hi, float64(j+1)) - math.Max(lo, float64(j))) / scale)
+			}
+			contribs[i] = contrib{start: start, weights: weights}
+
+		default:
+			k := kernelBilinear
+			if filter == FilterLanczos {
+				k = kernelLanczos
+			}
+			// widen kernel when shrinking, so every source pixel is used
+			fscale := math.Max(scale, 1)
+			center := (float64(i) + 0.5) * scale
+			left := int(math.Floor(center - k.support*fscale))
+			right := int(math.Ceil(center + k.support*fscale))
+
+			start, end := left, right
+			if start < 0 {
+				start = 0
+			}
+			if end > src {
+				end = src
+			}
+			weights := make([]float32, end-start)
+			sum := 0.0
+			for j := left; j < right; j++ {
+				w := k.at((float64(j) + 0.5 - center) / fscale)
+				if w == 0 {
+					continue
+				}
+				// outside pixels repeat the edge
+				jj := j
+				if jj < start {
+					jj = start
+				}
+				if jj >= end {
+					jj = end - 1
+				}
+				weights[jj-start] += float32(w)
+				sum += w
+			}
+			if sum != 0 {
+				for j := range weights {
+					weights[j] /= float32(sum)
+				}
+			}
+			contribs[i] = contrib{start: start, weights: weights}
+		}
+	}
+
+	return contribs
+}
+
+// resamplePlane resizes a plane of interleaved 8 bit channels, horizontally then vertically
+func resamplePlane(src []uint8, sw, sh, sstride int, dst []uint8, dw, dh, dstride, channels int, filter string) {
+	hc := makeContribs(sw, dw, filter)
+	vc := makeContribs(sh, dh, filter)
+
+	// horizontal pass, sh rows of dw pixels
+	tw := dw * channels
+	tmp := make([]float32, tw*sh)
+	for y := 0; y < sh; y++ {
+		row := src[y*sstride:]
+		out := tmp[y*tw:]
+		for x, c := range hc {
+			for ch := 0; ch < channels; ch++ {
+				var sum float32
+				for k, w := range c.weights {
+					sum += w * float32(row[(c.start+k)*channels+ch])
+				}
+				out[x*channels+ch] = sum
+			}
+		}
+	}
+
+	// vertical pass, row by row so memory is read in order
+	acc := make([]float32, tw)
+	for y, c := range vc {
+		for x := range acc {
+			acc[x] = 0
+		}
+		for k, w := range c.weights {
+			row := tmp[(c.start+k)*tw : (c.start+k+1)*tw]
+			for x, v := range row {
+				acc[x] += w * v
+			}
+		}
+
+		out := dst[y*dstride : y*dstride+tw]
+		for x, v := range acc {
+			out[x] = clampUint8(v)
+		}
+	}
+}
+
+// clampUint8 rounds to 0-255
+func clampUint8(v float32) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+// chromaSize gives Cb Cr plane size of rectangle for the subsample ratio, ok false for unknown ratio
+func chromaSize(r image.Rectangle, ratio image.YCbCrSubsampleRatio) (w, h int, ok bool) {
+	halfW := (r.Max.X+1)/2 - r.Min.X/2
+	halfH := (r.Max.Y+1)/2 - r.Min.Y/2
+	quarterW := (r.Max.X+3)/4 - r.Min.X/4
+
+	switch ratio {
+	case image.YCbCrSubsampleRatio444:
+		return r.Dx(), r.Dy(), true
+	case image.YCbCrSubsampleRatio422:
+		return halfW, r.Dy(), true
+	case image.YCbCrSubsampleRatio420:
+		return halfW, halfH, true
+	case image.YCbCrSubsampleRatio440:
+		return r.Dx(), halfH, true
+	case image.YCbCrSubsampleRatio411:
+		return quarterW, r.Dy(), true
+	case image.YCbCrSubsampleRatio410:
+		return quarterW, halfH, true
+	}
+	return 0, 0, false
+}
+
+// Resample resizes image to w x h with the filter.
+// jpeg (YCbCr), gray and RGBA images are resampled in their own format, others are converted to RGBA first
+func Resample(m image.Image, w, h int, filter string) image.Image {
+	b := m.Bounds()
+	if b.Dx() == w && b.Dy() == h {
+		return m
+	}
+	rect := image.Rect(0, 0, w, h)
+
+	switch src := m.(type) {
+	case *image.YCbCr:
+		scw, sch, ok := chromaSize(b, src.SubsampleRatio)
+		if !ok {
+			break
+		}
+		dst := image.NewYCbCr(rect, src.SubsampleRatio)
+		dcw, dch, _ := chromaSize(rect, src.SubsampleRatio)
+
+		resamplePlane(src.Y[src.YOffset(b.Min.X, b.Min.Y):], b.Dx(), b.Dy(), src.YStride, dst.Y, w, h, dst.YStride, 1, filter)
+		coff := src.COffset(b.Min.X, b.Min.Y)
+		resamplePlane(src.Cb[coff:], scw, sch, src.CStride, dst.Cb, dcw, dch, dst.CStride, 1, filter)
+		resamplePlane(src.Cr[coff:], scw, sch, src.CStride, dst.Cr, dcw, dch, dst.CStride, 1, filter)
+		return dst
+
+	case *image.Gray:
+		dst := image.NewGray(rect)
+		resamplePlane(src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], b.Dx(), b.Dy(), src.Stride, dst.Pix, w, h, dst.Stride, 1, filter)
+		return dst
+
+	case *image.RGBA:
+		dst := image.NewRGBA(rect)
+		resamplePlane(src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], b.Dx(), b.Dy(), src.Stride, dst.Pix, w, h, dst.Stride, 4, filter)
+		return dst
+
+	case *image.NRGBA:
+		dst := image.NewNRGBA(rect)
+		resamplePlane(src.Pix[src.PixOffset(b.Min.X, b.Min.Y):], b.Dx(), b.Dy(), src.Stride, dst.Pix, w, h, dst.Stride, 4, filter)
+		return dst
+	}
+
+	// paletted, 16 bit, cmyk etc.
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), m, b.Min, draw.Src)
+	return Resample(rgba, w, h, filter)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestMakeContribs(t *testing.T) {
+	tests := []struct {
+		src, dst int
+	}{
+		{100, 10},
+		{100, 33},
+		{10, 100},
+		{7, 3},
+		{3, 7},
+		{1, 5},
+		{5, 1},
+		{9, 9},
+	}
+
+	for _, filter := range []string{FilterNearest, FilterBilinear, FilterArea, FilterLanczos} {
+		for _, tt := range tests {
+			contribs := makeContribs(tt.src, tt.dst, filter)
+			if len(contribs) != tt.dst {
+				t.Errorf("%s %d->%d: %d contribs, want %d", filter, tt.src, tt.dst, len(contribs), tt.dst)
+				continue
+			}
+			for i, c := range contribs {
+				if c.start < 0 || c.start+len(c.weights) > tt.src || len(c.weights) == 0 {
+					t.Errorf("%s %d->%d: pixel %d uses source %d+%d", filter, tt.src, tt.dst, i, c.start, len(c.weights))
+					continue
+				}
+				sum := 0.0
+				for _, w := range c.weights {
+					sum += float64(w)
+				}
+				if math.Abs(sum-1) > 1e-4 {
+					t.Errorf("%s %d->%d: pixel %d weights sum to %g", filter, tt.src, tt.dst, i, sum)
+				}
+			}
+		}
+	}
+}
+
+func TestResampleSize(t *testing.T) {
+	src := image.Rect(0, 0, 37, 23)
+	images := []struct {
+		name string
+		m    image.Image
+	}{
+		{"gray", image.NewGray(src)},
+		{"rgba", image.NewRGBA(src)},
+		{"nrgba", image.NewNRGBA(src)},
+		{"ycbcr 420", image.NewYCbCr(src, image.YCbCrSubsampleRatio420)},
+		{"paletted", image.NewPaletted(src, color.Palette{color.Black, color.White})},
+	}
+	sizes := []struct {
+		w, h int
+	}{
+		{10, 7},
+		{80, 50},
+		{1, 1},
+		{37, 1},
+	}
+
+	for _, filter := range []string{FilterNearest, FilterBilinear, FilterArea, FilterLanczos} {
+		for _, im := range images {
+			for _, size := range sizes {
+				got := Resample(im.m, size.w, size.h, filter).Bounds()
+				if got != image.Rect(0, 0, size.w, size.h) {
+					t.Errorf("%s %s to %dx%d: bounds %v", filter, im.name, size.w, size.h, got)
+				}
+			}
+		}
+	}
+}
+
+func TestResampleUniform(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 37, 23))
+	for i := range src.Pix {
+		src.Pix[i] = 128
+	}
+
+	for _, filter := range []string{FilterNearest, FilterBilinear, FilterArea, FilterLanczos} {
+		for _, size := range []image.Point{{10, 7}, {80, 50}} {
+			dst := Resample(src, size.X, size.Y, filter).(*image.Gray)
+			for i, v := range dst.Pix {
+				if v != 128 {
+					t.Errorf("%s to %v: pixel %d is %d, want 128", filter, size, i, v)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestResampleYCbCrSubImage(t *testing.T) {
+	// sub-image starting at odd pixel, so its chroma is not aligned with the parent planes.
+	// nearest filter maps the corner pixels straight through, so both corners must keep their colour
+	ratios := []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio444,
+		image.YCbCrSubsampleRatio422,
+		image.YCbCrSubsampleRatio420,
+		image.YCbCrSubsampleRatio440,
+		image.YCbCrSubsampleRatio411,
+		image.YCbCrSubsampleRatio410,
+	}
+	rnd := rand.New(rand.NewSource(1))
+
+	for _, ratio := range ratios {
+		parent := image.NewYCbCr(image.Rect(0, 0, 12, 10), ratio)
+		for _, plane := range [][]uint8{parent.Y, parent.Cb, parent.Cr} {
+			rnd.Read(plane)
+		}
+		src := parent.SubImage(image.Rect(1, 1, 10, 8)).(*image.YCbCr)
+
+		dst, ok := Resample(src, 6, 5, FilterNearest).(*image.YCbCr)
+		if !ok {
+			t.Errorf("%v: not resampled as YCbCr", ratio)
+			continue
+		}
+		if dst.SubsampleRatio != ratio {
+			t.Errorf("%v: resampled with ratio %v", ratio, dst.SubsampleRatio)
+		}
+
+		corners := []struct {
+			dst, src image.Point
+		}{
+			{image.Pt(0, 0), image.Pt(1, 1)},
+			{image.Pt(5, 4), image.Pt(9, 7)},
+		}
+		for _, c := range corners {
+			got := dst.YCbCrAt(c.dst.X, c.dst.Y)
+			want := src.YCbCrAt(c.src.X, c.src.Y)
+			if got != want {
+				t.Errorf("%v: pixel %v is %v, want %v of source %v", ratio, c.dst, got, want, c.src)
+			}
+		}
+	}
+}
+
+func TestChromaSize(t *testing.T) {
+	tests := []struct {
+		r     image.Rectangle
+		ratio image.YCbCrSubsampleRatio
+		w, h  int
+	}{
+		{image.Rect(0, 0, 10, 8), image.YCbCrSubsampleRatio444, 10, 8},
+		{image.Rect(0, 0, 10, 8), image.YCbCrSubsampleRatio420, 5, 4},
+		{image.Rect(0, 0, 9, 7), image.YCbCrSubsampleRatio420, 5, 4},
+		{image.Rect(1, 1, 10, 8), image.YCbCrSubsampleRatio420, 5, 4},
+		{image.Rect(1, 1, 9, 7), image.YCbCrSubsampleRatio420, 5, 4},
+		{image.Rect(1, 1, 10, 8), image.YCbCrSubsampleRatio422, 5, 7},
+		{image.Rect(1, 1, 10, 8), image.YCbCrSubsampleRatio440, 9, 4},
+		{image.Rect(1, 1, 10, 8), image.YCbCrSubsampleRatio411, 3, 7},
+		{image.Rect(3, 0, 10, 8), image.YCbCrSubsampleRatio410, 3, 4},
+	}
+
+	for _, tt := range tests {
+		w, h, ok := chromaSize(tt.r, tt.ratio)
+		if !ok || w != tt.w || h != tt.h {
+			t.Errorf("chromaSize(%v, %v) = %d %d %t, want %d %d", tt.r, tt.ratio, w, h, ok, tt.w, tt.h)
+		}
+	}
+
+	if _, _, ok := chromaSize(image.Rect(0, 0, 4, 4), image.YCbCrSubsampleRatio(99)); ok {
+		t.Error("unknown ratio gave ok")
+	}
+}
@@ -1,15 +1,24 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/pprof"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
+// ShutdownTimeout how long running requests are given to finish on shutdown
+var ShutdownTimeout = 10 * time.Second
+
 // Server holds link to database and configuration
 type Server struct {
 	Database *FlatDB
@@ -24,16 +33,66 @@ func (svr *Server) Start() {
 	db := svr.Database
 
 	// setup sessions
-	httpSession := &SessionStore{
-		serverConfig: cfg,
-	}
+	httpSession := NewSessionStore(cfg)
 
 	// previous sessions
-	//err := httpSession.Load()
-	//if err != nil {
-	//	fmt.Println("Error loading httpSession for previous sessions")
-	//	log.Fatal(err)
-	//}
+	err := httpSession.Load()
+	if err != nil {
+		fmt.Println("Error loading httpSession for previous sessions")
+		log.Fatal(err)
+	}
+	go httpSession.Run()
+
+	// failed login tracking
+	loginGuard := NewLoginGuard(loginAttemptsFile(cfg))
+	err = loginGuard.Load()
+	if err != nil {
+		fmt.Println("failed to load login attempts", err)
+	}
+	go loginGuard.Run()
+
+	// reading progress of each user
+	progress, err := NewProgressStore(filepath.Join(cfg.PathDir, "progress"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// user from single user config inherits progress stored in book catalogue,
+	// then config without the old login is saved
+	if cfg.legacyUsername != "" {
+		if !progress.Exists(cfg.legacyUsername) {
+			err = progress.Seed(cfg.legacyUsername, db)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		err = cfg.Save(cfg.PathConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// resized pages kept on disk
+	var pageCache *PageCache
+	if cfg.PageCacheMB > 0 {
+		pageCache = NewPageCache(filepath.Join(cfg.PathCache, "pages"), int64(cfg.PageCacheMB)<<20)
+		err = pageCache.Load()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// reader pages, next pages are warmed in background
+	pageReader := NewPageReader(pageCache, cfg.Prefetch)
+
+	// optional basic auth for clients without cookie
+	basicAuth := NewBasicAuth(cfg, loginGuard)
+
+	// csrf token for forms
+	csrf, err := NewCSRF(cfg, httpSession)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	h := http.NewServeMux()
 
 	// public folder access
@@ -51,26 +110,118 @@ func (svr *Server) Start() {
 	h.HandleFunc("/", handlerFS(fserv))
 
 	// public api, page
-	h.HandleFunc("/login", loginPOST(httpSession, cfg))
-	h.HandleFunc("/login.html", loginGet(cfg, db, tmplLogin))
+	h.HandleFunc("/login", loginPOST(httpSession, cfg, loginGuard))
+	h.HandleFunc("/login.html", loginGet(cfg, db, basicAuth, csrf, tmplLogin))
+	h.HandleFunc("/logout", logoutPOST(httpSession))
 	h.HandleFunc("/free", func(w http.ResponseWriter, r *http.Request) {
 		runtime.GC()
 		w.Write([]byte("freed"))
 	})
 
 	// private api, page
-	h.HandleFunc("/api/thumbnail/", renderThumbnail(db, cfg)) // /thumbnail/{bookID}              get book cover thumbnail
-	h.HandleFunc("/api/read/", readPage(db, true))            // /read?book={bookID}&page={page}  get image and update last read
-	h.HandleFunc("/browse.html", browseGet(cfg, db, tmplBrowse))
-	h.HandleFunc("/legacy.html", browseGet(cfg, db, tmplBrowseLegacy))
-	h.HandleFunc("/read.html", readGet(cfg, db, tmplRead))
+	h.HandleFunc("/api/thumbnail/", renderThumbnail(db, cfg))                              // /thumbnail/{bookID}              get book cover thumbnail
+	h.HandleFunc("/api/read/", readPage(cfg, db, progress, httpSession, pageReader, true)) // /read?book={bookID}&page={page}  get image and update last read
+	h.HandleFunc("/browse.html", browseGet(cfg, db, httpSession, progress, tmplBrowse))
+	h.HandleFunc("/legacy.html", browseGet(cfg, db, httpSession, progress, tmplBrowseLegacy))
+	h.HandleFunc("/read.html", readGet(cfg, db, progress, httpSession, csrf, tmplRead))
+	h.HandleFunc("/api/fav", favPOST(cfg, db, progress))
+	h.HandleFunc("/api/ranking", rankingPOST(cfg, db, progress))
+	h.HandleFunc("/api/bookmark", bookmarkPOST(cfg, db, progress))
+	h.HandleFunc("/api/crop", cropPOST(httpSession))
+
+	// account api, page
+	h.HandleFunc("/account.html", accountGet(cfg, httpSession, csrf, tmplAccount))
+	h.HandleFunc("/api/account/session", accountSessionPOST(httpSession))
+	h.HandleFunc("/api/account/token", accountTokenPOST(cfg, httpSession, csrf, tmplAccount))
+
+	// settings api, page
+	h.HandleFunc("/settings.html", settingsGet(cfg, httpSession, csrf, tmplSettings))
+	h.HandleFunc("/api/settings", settingsPOST(cfg, httpSession))
+
+	// admin api, page
+	h.HandleFunc("/admin.html", adminGet(cfg, db, loginGuard, pageCache, csrf, tmplAdmin))
+	h.HandleFunc("/api/admin/user", adminUserPOST(cfg, progress))
+	h.HandleFunc("/api/admin/rescan", adminRescanPOST(cfg, db))
+	h.HandleFunc("/api/admin/book", adminBookPOST(cfg, db, pageCache))
+	h.HandleFunc("/api/admin/unblock", adminUnblockPOST(loginGuard))
+	h.HandleFunc("/api/admin/cache", adminCachePOST(pageCache))
 
 	// middleware
 	slog := svrLogging(h, httpSession, cfg)
-	h1 := CheckAuthHandler(slog, httpSession, cfg)
+	h1 := CheckAuthHandler(CSRFHandler(slog, csrf), httpSession, cfg, basicAuth)
+
+	// refuse unknown clients before anything else
+	ipFilter, err := NewIPFilter(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	h1 = IPFilterHandler(h1, ipFilter)
 
 	port := cfg.IP + ":" + strconv.Itoa(cfg.Port)
-	fmt.Println("listening on", port)
 	fmt.Println("allowed dirs: " + strings.Join(cfg.AllowedDirs, ", "))
-	log.Fatal(http.ListenAndServe(port, h1))
+
+	srv := &http.Server{Addr: port, Handler: h1}
+	servers := []*http.Server{srv}
+
+	var certFile, keyFile string
+	if cfg.TLS {
+		certFile, keyFile, err = tlsFiles(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// plain http alongside, for clients that cannot do modern tls
+		if cfg.HTTPPort > 0 {
+			httpSrv := &http.Server{Addr: cfg.IP + ":" + strconv.Itoa(cfg.HTTPPort), Handler: h1}
+			servers = append(servers, httpSrv)
+			fmt.Println("listening on http", httpSrv.Addr)
+			go func() {
+				err := httpSrv.ListenAndServe()
+				if err != http.ErrServerClosed {
+					log.Fatal(err)
+				}
+			}()
+		}
+	}
+
+	// on signal stop taking requests and let running ones finish
+	stopped := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		fmt.Println("shutting down")
+
+		ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		for _, s := range servers {
+			err := s.Shutdown(ctx)
+			if err != nil {
+				fmt.Println("failed to finish requests", err)
+			}
+		}
+		close(stopped)
+	}()
+
+	if cfg.TLS {
+		fmt.Println("listening on https", port)
+		err = srv.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		fmt.Println("listening on", port)
+		err = srv.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-stopped
+
+	// sessions and login attempts are only saved periodically, save the rest now nothing changes them
+	err = httpSession.save()
+	if err != nil {
+		fmt.Println("failed to save sessions", err)
+	}
+	err = loginGuard.save()
+	if err != nil {
+		fmt.Println("failed to save login attempts", err)
+	}
 }
@@ -2,12 +2,16 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/gob"
+	"encoding/hex"
 	"errors"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"path"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -20,23 +24,54 @@ import (
 
 // SessionStore holds all the http sessions and other details
 type SessionStore struct {
-	sessions     []*session
+	mutex        sync.RWMutex        // guards sessions and session values
+	sessions     map[string]*session // sessions by session id
+	file         gobFile             // where sessions are saved, changes are counted under mutex
 	serverConfig *Config
 }
 
 // session http session state
 type session struct {
-	Expiry time.Time              // expiry time that this cookie becomes invalid
-	ID     string                 // session id
-	Values map[string]interface{} // holds data in key:value
+	Expiry    time.Time              // expiry time that this cookie becomes invalid
+	ID        string                 // session id
+	Values    map[string]interface{} // holds data in key:value
+	UserAgent string                 // browser of the last request
+	IP        string                 // client ip of the last request
+	LastSeen  time.Time              // time of the last request
 }
 
+// SessionInfo is session detail shown to the user, without the session values
+type SessionInfo struct {
+	Handle    string // identifies the session without revealing the session id
+	UserAgent string
+	IP        string
+	LastSeen  time.Time
+	Expiry    time.Time
+	Current   bool // session of the request
+}
+
+// SessionSeenInterval how often last seen is updated, stops every request marking sessions changed
+var SessionSeenInterval = time.Minute
+
+// SessionSaveInterval how often changed sessions are saved and expired sessions are scrubbed
+var SessionSaveInterval = time.Minute
+
 // ErrSessionExpired session is expired
 var ErrSessionExpired = errors.New("expired session")
 
 // ErrNoSession session does not exist
 var ErrNoSession = errors.New("no session")
 
+// NewSessionStore creates blank session store
+func NewSessionStore(cfg *Config) *SessionStore {
+	ss := &SessionStore{
+		sessions:     make(map[string]*session),
+		serverConfig: cfg,
+	}
+	ss.file.path = ss.sessionsFile()
+	return ss
+}
+
 // find session in request
 func (ss *SessionStore) find(r *http.Request) (*session, error) {
 	sid, err := r.Cookie("SessionID")
@@ -44,10 +79,12 @@ func (ss *SessionStore) find(r *http.Request) (*session, error) {
 		return nil, err
 	}
 
-	for _, s := range ss.sessions {
-		if s.ID == sid.Value && s.Expiry.After(time.Now()) {
-			return s, nil
-		}
+	ss.mutex.RLock()
+	defer ss.mutex.RUnlock()
+
+	s := ss.sessions[sid.Value]
+	if s != nil && s.Expiry.After(time.Now()) {
+		return s, nil
 	}
 
 	return nil, ErrNoSession
@@ -58,31 +95,92 @@ func (ss *SessionStore) create(w http.ResponseWriter, r *http.Request) *session
 	cid := GenerateString(20)
 
 	newSession := &session{
-		Expiry: time.Now().AddDate(0, 1, 0), // set default to 1 month expiry
-		ID:     cid,
-		Values: make(map[string]interface{}),
+		Expiry:    time.Now().AddDate(0, 1, 0), // set default to 1 month expiry
+		ID:        cid,
+		Values:    make(map[string]interface{}),
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+		LastSeen:  time.Now(),
 	}
 
-	ss.sessions = append(ss.sessions, newSession)
-	ss.save()
+	ss.mutex.Lock()
+	ss.sessions[newSession.ID] = newSession
+	ss.file.changed()
+	ss.mutex.Unlock()
 
 	// set browser session cookie
-	cki := &http.Cookie{
-		Name:  "SessionID",
-		Value: newSession.ID,
-		Path:  "/",
-		// commented out so works with go1.8
-		// SameSite: http.SameSiteStrictMode,
-	}
+	cki := sessionCookie(r, newSession)
 	http.SetCookie(w, cki)
 	// remember to stop other .create(), making multiple session id
-	r.AddCookie(cki)
+	setRequestCookie(r, cki)
 
 	return newSession
 }
 
+// sessionCookie builds the browser session cookie
+func sessionCookie(r *http.Request, s *session) *http.Cookie {
+	cki := &http.Cookie{
+		Name:     "SessionID",
+		Value:    s.ID,
+		Path:     "/",
+		Expires:  s.Expiry,
+		HttpOnly: true,
+		// plain http is still needed by legacy clients, so only mark secure when served over https
+		Secure: r.TLS != nil,
+	}
+	// go1.11 and later only
+	setCookieSameSite(cki)
+
+	return cki
+}
+
+// setRequestCookie replaces the cookie of the same name in the request,
+// so the rest of the request sees the new value instead of the one sent by browser
+func setRequestCookie(r *http.Request, cki *http.Cookie) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != cki.Name {
+			r.AddCookie(c)
+		}
+	}
+	r.AddCookie(&http.Cookie{Name: cki.Name, Value: cki.Value})
+}
+
+// Rotate moves current session data to a new session id and remove the old one,
+// used on login so session id known before login (fixation) becomes useless
+func (ss *SessionStore) Rotate(w http.ResponseWriter, r *http.Request) {
+	old := ss.ready(w, r)
+	ns := ss.create(w, r)
+
+	ss.mutex.Lock()
+	for k, v := range old.Values {
+		ns.Values[k] = v
+	}
+	delete(ss.sessions, old.ID)
+	ss.file.changed()
+	ss.mutex.Unlock()
+}
+
+// newRequestSession creates session that only lives for the request, it is not stored
+func newRequestSession(r *http.Request) *session {
+	return &session{
+		Expiry:    time.Now().Add(time.Minute),
+		ID:        GenerateString(20),
+		Values:    make(map[string]interface{}),
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+		LastSeen:  time.Now(),
+	}
+}
+
 // ready makes sure session exist, if not it will create one on the spot
 func (ss *SessionStore) ready(w http.ResponseWriter, r *http.Request) *session {
+	// request scoped session
+	if s, ok := r.Context().Value(ctxKeySession).(*session); ok {
+		return s
+	}
+
 	// find cookie
 	_, err := r.Cookie("SessionID")
 	if err != nil {
@@ -106,28 +204,53 @@ func (ss *SessionStore) ready(w http.ResponseWriter, r *http.Request) *session {
 	}
 
 	// existing session
+	ss.seen(s, r)
 	return s
 }
 
-// saves the sessions from memory into drive for long term storage
-func (ss *SessionStore) save() error {
-	b := new(bytes.Buffer)
-	err := gob.NewEncoder(b).Encode(ss.sessions)
-	if err != nil {
-		return err
+// seen records the request as the latest activity of session
+func (ss *SessionStore) seen(s *session, r *http.Request) {
+	now := time.Now()
+	ua := r.UserAgent()
+	ip := clientIP(r)
+
+	ss.mutex.RLock()
+	changed := now.Sub(s.LastSeen) > SessionSeenInterval || s.UserAgent != ua || s.IP != ip
+	ss.mutex.RUnlock()
+	if !changed {
+		return
 	}
 
-	err = ioutil.WriteFile(path.Join(ss.serverConfig.PathDir, "sessions"), b.Bytes(), 0664)
-	if err != nil {
-		return err
-	}
+	ss.mutex.Lock()
+	s.LastSeen = now
+	s.UserAgent = ua
+	s.IP = ip
+	ss.file.changed()
+	ss.mutex.Unlock()
+}
 
-	return nil
+// sessionsFile is where sessions are saved
+func (ss *SessionStore) sessionsFile() string {
+	return path.Join(ss.serverConfig.PathDir, "sessions")
+}
+
+// saves the sessions from memory into drive for long term storage
+func (ss *SessionStore) save() error {
+	return ss.file.save(&ss.mutex, func(enc *gob.Encoder) error {
+		return enc.Encode(ss.sessions)
+	})
+}
+
+// dirty has sessions changed since last save
+func (ss *SessionStore) dirty() bool {
+	ss.mutex.RLock()
+	defer ss.mutex.RUnlock()
+	return ss.file.dirty()
 }
 
 // Load previously saved session from drive to memory
 func (ss *SessionStore) Load() error {
-	f := path.Join(ss.serverConfig.PathDir, "sessions")
+	f := ss.sessionsFile()
 
 	isExist, err := IsFileExists(f)
 	if err != nil {
@@ -143,16 +266,46 @@ func (ss *SessionStore) Load() error {
 		return err
 	}
 
-	bb := bytes.NewBuffer(b)
-
-	err = gob.NewDecoder(bb).Decode(&ss.sessions)
+	sessions := make(map[string]*session)
+	err = gob.NewDecoder(bytes.NewBuffer(b)).Decode(&sessions)
 	if err != nil {
-		return err
+		// older version saved sessions as list
+		var list []*session
+		err2 := gob.NewDecoder(bytes.NewBuffer(b)).Decode(&list)
+		if err2 != nil {
+			return err
+		}
+		for _, s := range list {
+			sessions[s.ID] = s
+		}
 	}
-	log.Printf("sessions loaded (%d)\n", len(ss.sessions))
+
+	ss.mutex.Lock()
+	ss.sessions = sessions
+	ss.mutex.Unlock()
+
+	log.Printf("sessions loaded (%d)\n", len(sessions))
+
+	ss.Scrub()
 	return nil
 }
 
+// Run saves changed sessions and scrub expired sessions periodically, it does not return
+func (ss *SessionStore) Run() {
+	for range time.Tick(SessionSaveInterval) {
+		ss.Scrub()
+
+		if !ss.dirty() {
+			continue
+		}
+
+		err := ss.save()
+		if err != nil {
+			log.Println("failed to save sessions", err)
+		}
+	}
+}
+
 // ID get current session id
 func (ss *SessionStore) ID(w http.ResponseWriter, r *http.Request) string {
 	s := ss.ready(w, r)
@@ -164,15 +317,19 @@ func (ss *SessionStore) ID(w http.ResponseWriter, r *http.Request) string {
 func (ss *SessionStore) Set(w http.ResponseWriter, r *http.Request, key string, value interface{}) {
 	s := ss.ready(w, r)
 
+	ss.mutex.Lock()
 	s.Values[key] = value
-
-	ss.save()
+	ss.file.changed()
+	ss.mutex.Unlock()
 }
 
 // Get current session data
 func (ss *SessionStore) Get(w http.ResponseWriter, r *http.Request, key string) interface{} {
 	s := ss.ready(w, r)
 
+	ss.mutex.RLock()
+	defer ss.mutex.RUnlock()
+
 	return s.Values[key]
 }
 
@@ -180,30 +337,103 @@ func (ss *SessionStore) Get(w http.ResponseWriter, r *http.Request, key string)
 func (ss *SessionStore) Delete(w http.ResponseWriter, r *http.Request) {
 	s := ss.ready(w, r)
 
-	s.Expiry = time.Unix(0, 0)
-
-	ss.Scrub()
+	ss.mutex.Lock()
+	delete(ss.sessions, s.ID)
+	ss.file.changed()
+	ss.mutex.Unlock()
 
 	// create new session
 	ss.create(w, r)
 }
 
+// UserSessions lists active sessions of the user, most recently seen first
+func (ss *SessionStore) UserSessions(w http.ResponseWriter, r *http.Request, username string) []SessionInfo {
+	current := ss.ready(w, r)
+	now := time.Now()
+
+	ss.mutex.RLock()
+	list := []SessionInfo{}
+	for _, s := range ss.sessions {
+		if s.Values[SessionUsername] != username || !s.Expiry.After(now) {
+			continue
+		}
+		list = append(list, SessionInfo{
+			Handle:    sessionHandle(s.ID),
+			UserAgent: s.UserAgent,
+			IP:        s.IP,
+			LastSeen:  s.LastSeen,
+			Expiry:    s.Expiry,
+			Current:   s == current,
+		})
+	}
+	ss.mutex.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastSeen.After(list[j].LastSeen)
+	})
+	return list
+}
+
+// sessionHandle gives non secret handle of session id, safe to show in page
+func sessionHandle(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Revoke deletes session of the user by handle, false if there is no such session of the user
+func (ss *SessionStore) Revoke(username, handle string) bool {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	for id, s := range ss.sessions {
+		if s.Values[SessionUsername] == username && sessionHandle(id) == handle {
+			delete(ss.sessions, id)
+			ss.file.changed()
+			return true
+		}
+	}
+	return false
+}
+
+// RevokeOthers deletes all sessions of the user except the current one, returns number deleted
+func (ss *SessionStore) RevokeOthers(w http.ResponseWriter, r *http.Request, username string) int {
+	current := ss.ready(w, r)
+
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	n := 0
+	for id, s := range ss.sessions {
+		if s != current && s.Values[SessionUsername] == username {
+			delete(ss.sessions, id)
+			n++
+		}
+	}
+	if n > 0 {
+		ss.file.changed()
+	}
+	return n
+}
+
 // Clear all sessions
 func (ss *SessionStore) Clear() {
-	ss.sessions = []*session{}
-	ss.save()
+	ss.mutex.Lock()
+	ss.sessions = make(map[string]*session)
+	ss.file.changed()
+	ss.mutex.Unlock()
 }
 
 // Scrub clear all expired sessions
 func (ss *SessionStore) Scrub() {
-	nss := []*session{}
+	now := time.Now()
 
-	for _, s := range ss.sessions {
-		if s.Expiry.After(time.Now()) {
-			nss = append(nss, s)
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	for id, s := range ss.sessions {
+		if !s.Expiry.After(now) {
+			delete(ss.sessions, id)
+			ss.file.changed()
 		}
 	}
-
-	ss.sessions = nss
-	ss.save()
 }
@@ -1,10 +1,9 @@
 package main
 
 import (
-	"log"
 	"fmt"
-	"sort"
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -91,7 +90,6 @@ OUTER:
 	return books
 }
 
-
 func filterBooksByTitle(books []*Book, filter string) []*Book {
 	return filterBooksBy(books, filter, "title")
 }
@@ -124,16 +122,15 @@ func sortBooksByAuthorTitle(books []*Book) []*Book {
 }
 
 func sortBooksByFav(books []*Book) []*Book {
-    // Custom less function to sort favorited books first
-    less := func(i, j int) bool {
-        return books[i].Fav > books[j].Fav
-    }
-    // Sort the books using the custom less function
-    sort.Slice(books, less)
-    return books
+	// Custom less function to sort favorited books first
+	less := func(i, j int) bool {
+		return books[i].Fav > books[j].Fav
+	}
+	// Sort the books using the custom less function
+	sort.Slice(books, less)
+	return books
 }
 
-
 func booksQuicksort(arr []*Book, byType string, low, high int) {
 	if low < high {
 		var pi int = booksPartition(arr, byType, low, high)
@@ -187,7 +184,7 @@ func booksPartition(arr []*Book, byType string, low, high int) int {
 			b := pivot.Fav
 
 			// natural compare
-			if (a > b) {
+			if a > b {
 				i++
 				arr[i], arr[j] = arr[j], arr[i]
 			}
@@ -200,121 +197,145 @@ func booksPartition(arr []*Book, byType string, low, high int) int {
 }
 
 //
-// FileInfoBasic, support functions
+// FileInfoBasic, sort pipeline
 //
 
-func fibsQuicksort(arr []*FileInfoBasic, byType string, low, high int) {
-	if low < high {
-		var pi int = fibsPartition(arr, byType, low, high)
+// sort keys accepted by the sortby parameter
+const (
+	sortOrderByFileName    = "name"      // file name, natural order
+	sortOrderByFileModTime = "time"      // file modified time
+	sortOrderByImportTime  = "itime"     // time book imported into db
+	sortOrderByReadTime    = "read"      // last read time
+	sortOrderByAuthor      = "author"    // author, then title, then number
+	sortOrderByPages       = "pages"     // total pages
+	sortOrderByRanking     = "ranking"   // 1-5 ranking
+	sortOrderByFav         = "fav"       // favourited first
+	sortOrderByRelevance   = "relevance" // search match quality, keeps the order given by search
+)
 
-		fibsQuicksort(arr, byType, low, pi-1)
-		fibsQuicksort(arr, byType, pi+1, high)
-	}
-}
+// sort direction accepted by the order parameter
+const (
+	sortAsc  = "asc"
+	sortDesc = "desc"
+)
 
-func fibsPartition(arr []*FileInfoBasic, byType string, low, high int) int {
-		var pivot *FileInfoBasic = arr[high]
+// fibLess compares a and b ascendingly for the sort key, returns -1, 0 or 1
+type fibLess func(a, b *FileInfoBasic) int
 
-		var i int = (low - 1)
+// compare by name, used as the tie breaker for other sort keys
+func fibCompareName(a, b *FileInfoBasic) int {
+	if a.Name == b.Name {
+		return 0
+	}
+	if AlphaNumCaseCompare(a.Name, b.Name) {
+		return -1
+	}
+	return 1
+}
 
-		for j := low; j < high; j++ {
-			switch byType {
-		case sortOrderByFileName:
-		    // sort by filename
-		    a := arr[j].Name
-		    b := pivot.Name
-		    if a < b {
-		        i++
-		        arr[i], arr[j] = arr[j], arr[i]
-		    }
-		case sortOrderByFileModTime:
-		    // sort by file modification time
-		    a := arr[j].ModTime
-		    b := pivot.ModTime
-		    if a.After(b) { // newest first
-		        i++
-		        arr[i], arr[j] = arr[j], arr[i]
-		    }
-		case sortOrderByReadTime:
-			// sort by read time
-			a := arr[j].Rtime
-			b := pivot.Rtime
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
 
-			// natural compare
-			if a < b { // oldest read first
-				i++
-				arr[i], arr[j] = arr[j], arr[i]
-			}
-		case sortOrderByAuthor:
-			// sort by author and title
-			a := arr[j].Book.Author + " " + arr[j].Book.Title + " " + arr[j].Book.Number
-			b := pivot.Book.Author + " " + arr[j].Book.Title + " " + arr[j].Book.Number
+// sortKeys maps sort key to comparer and its default direction
+var sortKeys = map[string]struct {
+	compare fibLess
+	order   string
+}{
+	sortOrderByFileName: {fibCompareName, sortAsc},
+	sortOrderByFileModTime: {func(a, b *FileInfoBasic) int {
+		return compareInt64(a.ModTime.Unix(), b.ModTime.Unix())
+	}, sortDesc},
+	sortOrderByImportTime: {func(a, b *FileInfoBasic) int {
+		return compareInt64(a.Itime, b.Itime)
+	}, sortDesc},
+	sortOrderByReadTime: {func(a, b *FileInfoBasic) int {
+		return compareInt64(a.Rtime, b.Rtime)
+	}, sortDesc},
+	sortOrderByAuthor: {func(a, b *FileInfoBasic) int {
+		x := a.Author + " " + a.Title + " " + a.Number
+		y := b.Author + " " + b.Title + " " + b.Number
+		if x == y {
+			return 0
+		}
+		if AlphaNumCaseCompare(x, y) {
+			return -1
+		}
+		return 1
+	}, sortAsc},
+	sortOrderByPages: {func(a, b *FileInfoBasic) int {
+		return compareInt64(a.Pages, b.Pages)
+	}, sortAsc},
+	sortOrderByRanking: {func(a, b *FileInfoBasic) int {
+		return compareInt64(a.Ranking, b.Ranking)
+	}, sortDesc},
+	sortOrderByFav: {func(a, b *FileInfoBasic) int {
+		return compareInt64(a.Fav, b.Fav)
+	}, sortDesc},
+	sortOrderByRelevance: {func(a, b *FileInfoBasic) int {
+		return 0
+	}, sortAsc},
+}
 
-			// natural compare
-			if a > b {
-				i++
-				arr[i], arr[j] = arr[j], arr[i]
-			}
+// validSortBy checks if sort key is supported
+func validSortBy(sortBy string) bool {
+	_, ok := sortKeys[sortBy]
+	return ok
+}
 
-		case sortOrderByFav: // not used
-		    // Define the specific sorting logic based on the favorite status
-		    // Example: Sort favorites before non-favorites
-		    //if arr[j].Book.Fav > pivot.Book.Fav {
-		//	i++
-			arr[i], arr[j] = arr[j], arr[i]
-		  //  }
-		}
+// sortOrderOrDefault gives sort direction, blank or unknown order falls back to the key default
+func sortOrderOrDefault(sortBy, order string) string {
+	if order == sortAsc || order == sortDesc {
+		return order
+	}
+	if key, ok := sortKeys[sortBy]; ok {
+		return key.order
 	}
+	return sortAsc
+}
 
-	arr[i+1], arr[high] = arr[high], arr[i+1]
+// sortFileList sorts listing by sort key and direction, directories always come first.
+// ties are broken by name so the listing is stable between pages. unknown key sorts by name
+func sortFileList(arr FileList, sortBy, order string) FileList {
+	key, ok := sortKeys[sortBy]
+	if !ok {
+		key = sortKeys[sortOrderByFileName]
+	}
+	desc := sortOrderOrDefault(sortBy, order) == sortDesc
 
-	return i + 1
-}
+	newArr := append(FileList{}, arr...)
+	sort.SliceStable(newArr, func(i, j int) bool {
+		a, b := newArr[i], newArr[j]
 
-//
-// FileInfoBasic, sort types
-//
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
 
-// sort by filename
-func sortByFileName(arr []*FileInfoBasic) []*FileInfoBasic {
-	newArr := append([]*FileInfoBasic{}, arr...)
-	fibsQuicksort(newArr, sortOrderByFileName, 0, len(arr)-1)
-	return newArr
-}
+		c := key.compare(a, b)
+		if desc {
+			c = -c
+		}
+		if c != 0 || sortBy == sortOrderByRelevance {
+			return c < 0
+		}
 
-// sort by read time, most recent one first
-func sortByReadTime(arr []*FileInfoBasic) []*FileInfoBasic {
-	newArr := append([]*FileInfoBasic{}, arr...)
-	fibsQuicksort(newArr, sortOrderByReadTime, 0, len(arr)-1)
-	return newArr
-}
+		return fibCompareName(a, b) < 0
+	})
 
-// sort by file modification time
-func sortByFileModTime(arr []*FileInfoBasic) []*FileInfoBasic {
-	newArr := append([]*FileInfoBasic{}, arr...)
-	fibsQuicksort(newArr, sortOrderByFileModTime, 0, len(arr)-1)
 	return newArr
 }
 
-// sort by author by title by number
-func sortByAuthorTitle(arr []*FileInfoBasic) []*FileInfoBasic {
-	newArr := append([]*FileInfoBasic{}, arr...)
-	fibsQuicksort(newArr, sortOrderByAuthor, 0, len(arr)-1)
+// favFirst moves favourited items to the front, keeping the existing order within each group
+func favFirst(arr FileList) FileList {
+	newArr := append(FileList{}, arr...)
+	sort.SliceStable(newArr, func(i, j int) bool {
+		return newArr[i].Fav > newArr[j].Fav
+	})
 	return newArr
 }
-
-// sort by fav
-func sortByFav(arr []*FileInfoBasic) []*FileInfoBasic {
-    newArr := append([]*FileInfoBasic{}, arr...)
-    // Custom less function to sort favorited books first
-    less := func(i, j int) bool {
-	return newArr[i].Fav > newArr[j].Fav
-    }
-    // Sort the books using the custom less function
-    sort.Slice(newArr, less)
-    // Print the sorted books
-    log.Println(arr)
-    log.Println("\n\n\n")
-    log.Println(newArr)
-    return newArr
-}
@@ -0,0 +1,137 @@
+package main
+
+// https support, self-signed certificate is generated when none is given
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SelfSignedValidity how long generated certificate is valid
+var SelfSignedValidity = 10 * 365 * 24 * time.Hour
+
+// tlsFiles gives certificate and key file paths, generates self-signed certificate if no certificate is given
+func tlsFiles(cfg *Config) (string, string, error) {
+	if cfg.TLSCert != "" || cfg.TLSKey != "" {
+		if cfg.TLSCert == "" || cfg.TLSKey == "" {
+			return "", "", fmt.Errorf("both tls_cert and tls_key are needed")
+		}
+		return cfg.TLSCert, cfg.TLSKey, nil
+	}
+
+	dir := filepath.Join(cfg.PathDir, "tls")
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	isExist, err := IsFileExists(certFile)
+	if err != nil {
+		return "", "", err
+	}
+	if isExist {
+		return certFile, keyFile, nil
+	}
+
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", "", err
+	}
+	err = generateSelfSignedCert(certFile, keyFile, localHosts())
+	if err != nil {
+		return "", "", err
+	}
+	fmt.Println("generated self-signed certificate", certFile)
+
+	return certFile, keyFile, nil
+}
+
+// localHosts gives host name and ip addresses of this machine, for certificate to be valid on lan
+func localHosts() []string {
+	hosts := []string{"localhost"}
+
+	hostname, err := os.Hostname()
+	if err == nil && hostname != "" && hostname != "localhost" {
+		hosts = append(hosts, hostname)
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				hosts = append(hosts, ipnet.IP.String())
+			}
+		}
+	}
+
+	return hosts
+}
+
+// generateSelfSignedCert creates self-signed certificate and key for hosts, writes them in pem format.
+// rsa is used rather than ecdsa because older e-reader browsers may not support ecdsa
+func generateSelfSignedCert(certFile, keyFile string, hosts []string) error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tmpl := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Shin Kamishibai"}, CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(SelfSignedValidity),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  false, // leaf, a trusted copy must not be able to sign other certificates
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	// key first, so a cert is never left without its key
+	err = writePEM(keyFile, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key), 0600)
+	if err != nil {
+		return err
+	}
+
+	return writePEM(certFile, "CERTIFICATE", der, 0644)
+}
+
+// writePEM writes pem block to file
+func writePEM(fpath, blockType string, der []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	err = pem.Encode(f, &pem.Block{Type: blockType, Bytes: der})
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
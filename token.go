@@ -0,0 +1,123 @@
+package main
+
+// long lived api tokens for clients that cannot use the login form
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// api token scopes
+const (
+	ScopeRead  = "read"  // read books, progress is not changed
+	ScopeWrite = "write" // read books and update progress
+)
+
+// APIToken is a token allowed to access /api/ as the user, only hash of the token is kept
+type APIToken struct {
+	ID      string    `json:"id"`      // short public id, for listing and revoking
+	Name    string    `json:"name"`    // what the token is for
+	Hash    string    `json:"hash"`    // sha256 of the token
+	Scope   string    `json:"scope"`   // read or write
+	Created time.Time `json:"created"` // creation time
+}
+
+// errors for api token
+var (
+	ErrTokenNameBlank    = errors.New("token name cannot be blank")
+	ErrTokenScopeInvalid = errors.New("token scope must be read or write")
+	ErrTokenNotFound     = errors.New("no such token")
+)
+
+// tokenHash hashes token for storing, token is random enough that salt is not needed
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// requestToken gives api token in the request, from Authorization: Bearer header or token query
+func requestToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	return r.URL.Query().Get("token")
+}
+
+// UserByToken finds user owning the api token, nil if no such token
+func (cfg *Config) UserByToken(token string) (*User, *APIToken) {
+	hash := []byte(tokenHash(token))
+
+	cfg.mutex.RLock()
+	defer cfg.mutex.RUnlock()
+
+	for _, u := range cfg.Users {
+		for _, t := range u.Tokens {
+			if subtle.ConstantTimeCompare([]byte(t.Hash), hash) == 1 {
+				return u, t
+			}
+		}
+	}
+	return nil, nil
+}
+
+// AddToken creates new api token for the user, the token is only returned here
+func (cfg *Config) AddToken(username, name, scope string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", ErrTokenNameBlank
+	}
+	if scope != ScopeRead && scope != ScopeWrite {
+		return "", ErrTokenScopeInvalid
+	}
+
+	token := GenerateString(40)
+	hash := tokenHash(token)
+	t := &APIToken{
+		ID:      hash[:8],
+		Name:    name,
+		Hash:    hash,
+		Scope:   scope,
+		Created: time.Now(),
+	}
+
+	err := cfg.UpdateUser(username, func(u *User) {
+		u.Tokens = append(append([]*APIToken{}, u.Tokens...), t)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// RevokeToken removes api token of the user by token id
+func (cfg *Config) RevokeToken(username, id string) error {
+	found := false
+	err := cfg.UpdateUser(username, func(u *User) {
+		// fn runs again when user changed meanwhile
+		found = false
+		tokens := []*APIToken{}
+		for _, t := range u.Tokens {
+			if t.ID == id {
+				found = true
+				continue
+			}
+			tokens = append(tokens, t)
+		}
+		u.Tokens = tokens
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrTokenNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// User is an account allowed to login
+type User struct {
+	Username    string      `json:"username"`               // login name, also used for the progress file name so unique ignoring case
+	Password    string      `json:"password,omitempty"`     // one time, and it will be cleared after computed
+	Salt        string      `json:"salt"`                   // salt for the crypt
+	Crypt       string      `json:"crypt"`                  // password hash
+	Algorithm   string      `json:"algorithm,omitempty"`    // password hash algorithm, blank for legacy sha256iter
+	Iterations  int         `json:"iterations,omitempty"`   // hash iterations used for crypt, blank for legacy 100,000
+	Role        string      `json:"role"`                   // admin, reader or guest
+	AllowedDirs []string    `json:"allowed_dirs,omitempty"` // restrict to these dirs, must be within config allowed dirs. blank for all
+	Tokens      []*APIToken `json:"tokens,omitempty"`       // api tokens
+}
+
+// user roles
+const (
+	RoleAdmin  = "admin"  // manage users, rescan, edit metadata, delete
+	RoleReader = "reader" // read, fav, progress
+	RoleGuest  = "guest"  // read only, no progress writes
+)
+
+// regexUsername allowed username characters, username is used as file name
+var regexUsername = regexp.MustCompile(`^[A-Za-z0-9._-]{3,32}$`)
+
+// errors for user
+var (
+	ErrUsernameInvalid = errors.New("username must be 3-32 characters of a-z, 0-9, dot, dash or underscore")
+	ErrUsernameDup     = errors.New("duplicate username")
+	ErrPasswordShort   = errors.New("password too short, min of 6")
+	ErrRoleInvalid     = errors.New("role must be admin, reader or guest")
+	ErrUserNotFound    = errors.New("no such user")
+	ErrLastAdmin       = errors.New("cannot remove the last admin")
+	ErrUserChanged     = errors.New("user changed meanwhile, try again")
+)
+
+// validate checks the user details are usable
+func (u *User) validate() error {
+	if !regexUsername.MatchString(u.Username) || u.Username[0] == '.' {
+		return ErrUsernameInvalid
+	}
+	if u.Crypt == "" && len(u.Password) < 6 {
+		return ErrPasswordShort
+	}
+	switch u.Role {
+	case RoleAdmin, RoleReader, RoleGuest:
+	default:
+		return ErrRoleInvalid
+	}
+	return nil
+}
+
+// IsAdmin can user manage the server
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// CanWrite can user change reading progress and favourite
+func (u *User) CanWrite() bool {
+	return u.Role == RoleAdmin || u.Role == RoleReader
+}
+
+// hashPassword computes crypt from the one time password, then clears it
+func (u *User) hashPassword(iter int) {
+	// generate salt, longer because of limited character list
+	u.Salt = GenerateString(128)
+	// calc password hash
+	u.Algorithm = HashAlgorithm
+	u.Iterations = iter
+	u.Crypt, _ = passwordHash(u.Algorithm, u.Password, u.Salt, u.Iterations)
+	// clear password
+	u.Password = ""
+}
+
+// hashIterations gives iterations the crypt was computed with
+func (u *User) hashIterations() int {
+	if u.Iterations <= 0 {
+		// before iterations were stored, it was always the same
+		return ConfigHashIterations
+	}
+	return u.Iterations
+}
+
+// CheckPassword verifies password against the stored hash
+func (u *User) CheckPassword(password string) bool {
+	return passwordMatch(u.Algorithm, password, u.Salt, u.hashIterations(), u.Crypt)
+}
+
+// NeedsRehash is the stored hash weaker than what the config wants now
+func (u *User) NeedsRehash(iter int) bool {
+	return u.Algorithm != HashAlgorithm || u.hashIterations() != iter
+}
+
+// User get user by username, nil if not found.
+// returned user must not be modified, use UpdateUser instead
+func (cfg *Config) User(username string) *User {
+	cfg.mutex.RLock()
+	defer cfg.mutex.RUnlock()
+
+	for _, u := range cfg.Users {
+		if u.Username == username {
+			return u
+		}
+	}
+	return nil
+}
+
+// UserList gives copy of the user list
+func (cfg *Config) UserList() []*User {
+	cfg.mutex.RLock()
+	defer cfg.mutex.RUnlock()
+
+	return append([]*User{}, cfg.Users...)
+}
+
+// UserAllowedDirs gives dirs the user is allowed to browse
+func (cfg *Config) UserAllowedDirs(u *User) []string {
+	if len(u.AllowedDirs) == 0 {
+		return cfg.AllowedDirs
+	}
+
+	dirs := []string{}
+	for _, dir := range u.AllowedDirs {
+		// ignore dir outside of server allowed dirs
+		if PathInDirs(cfg.AllowedDirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// AddUser adds new user and save config
+func (cfg *Config) AddUser(u *User) error {
+	err := u.validate()
+	if err != nil {
+		return err
+	}
+	if u.Crypt == "" {
+		u.hashPassword(cfg.Iterations)
+	}
+
+	cfg.mutex.Lock()
+	for _, u2 := range cfg.Users {
+		// progress file of Bob and bob is the same on case insensitive file system
+		if strings.EqualFold(u2.Username, u.Username) {
+			cfg.mutex.Unlock()
+			return ErrUsernameDup
+		}
+	}
+	cfg.Users = append(cfg.Users, u)
+	cfg.mutex.Unlock()
+
+	return cfg.Save(cfg.PathConfig)
+}
+
+// UpdateUser changes user with fn and save config.
+// fn receives a copy, so user in use by other requests are not changed half way.
+// new password is hashed without holding the lock, fn runs again if user changed meanwhile
+func (cfg *Config) UpdateUser(username string, fn func(u *User)) error {
+	for {
+		cur := cfg.User(username)
+		if cur == nil {
+			return ErrUserNotFound
+		}
+
+		u := *cur
+		fn(&u)
+		// username cannot be changed, it is the key for progress
+		u.Username = cur.Username
+		if u.Password != "" {
+			u.hashPassword(cfg.Iterations)
+		}
+
+		err := u.validate()
+		if err != nil {
+			return err
+		}
+
+		err = cfg.swapUser(cur, &u)
+		if err == ErrUserChanged {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		return cfg.Save(cfg.PathConfig)
+	}
+}
+
+// swapUser replaces user cur with u, cur must still be the current copy so changes made meanwhile are not lost
+func (cfg *Config) swapUser(cur, u *User) error {
+	cfg.mutex.Lock()
+	defer cfg.mutex.Unlock()
+
+	for i, u2 := range cfg.Users {
+		if u2.Username != cur.Username {
+			continue
+		}
+		if u2 != cur {
+			return ErrUserChanged
+		}
+		if cur.IsAdmin() && !u.IsAdmin() && cfg.adminCount() <= 1 {
+			return ErrLastAdmin
+		}
+		cfg.Users[i] = u
+		return nil
+	}
+
+	// removed meanwhile
+	return ErrUserNotFound
+}
+
+// RehashPassword stores new hash of the known right password, with iterations config wants now.
+// hash is computed without holding the lock, it is not stored when user was changed or removed meanwhile
+func (cfg *Config) RehashPassword(username, password string) error {
+	cur := cfg.User(username)
+	if cur == nil {
+		return ErrUserNotFound
+	}
+
+	u := *cur
+	u.Password = password
+	u.hashPassword(cfg.Iterations)
+
+	err := cfg.swapUser(cur, &u)
+	if err != nil {
+		return err
+	}
+
+	return cfg.Save(cfg.PathConfig)
+}
+
+// DeleteUser removes user and save config, reading progress of the user is removed by ProgressStore.Remove
+func (cfg *Config) DeleteUser(username string) error {
+	cfg.mutex.Lock()
+
+	users := []*User{}
+	for _, u := range cfg.Users {
+		if u.Username == username {
+			if u.IsAdmin() && cfg.adminCount() <= 1 {
+				cfg.mutex.Unlock()
+				return ErrLastAdmin
+			}
+			continue
+		}
+		users = append(users, u)
+	}
+	if len(users) == len(cfg.Users) {
+		cfg.mutex.Unlock()
+		return ErrUserNotFound
+	}
+	cfg.Users = users
+	cfg.mutex.Unlock()
+
+	return cfg.Save(cfg.PathConfig)
+}
+
+// adminCount number of admin users, caller must hold the lock
+func (cfg *Config) adminCount() int {
+	i := 0
+	for _, u := range cfg.Users {
+		if u.IsAdmin() {
+			i++
+		}
+	}
+	return i
+}
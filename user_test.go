@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAddUserDuplicate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &Config{PathConfig: filepath.Join(dir, "config.json"), Iterations: 1}
+	err = cfg.AddUser(&User{Username: "bob", Password: "pass123", Role: RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		username string
+		want     error
+	}{
+		{"bob", ErrUsernameDup},
+		{"Bob", ErrUsernameDup},
+		{"BOB", ErrUsernameDup},
+		{"bobby", nil},
+	}
+	for _, tt := range tests {
+		err := cfg.AddUser(&User{Username: tt.username, Password: "pass123", Role: RoleReader})
+		if err != tt.want {
+			t.Errorf("AddUser(%q) = %v, want %v", tt.username, err, tt.want)
+		}
+	}
+}